@@ -2,59 +2,106 @@ package shadowsocksr
 
 import (
 	"errors"
-	cipher2 "github.com/v2rayA/shadowsocksR/streamCipher"
 	"net"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/v2rayA/shadowsocksR/config"
 	"github.com/v2rayA/shadowsocksR/obfs"
 	"github.com/v2rayA/shadowsocksR/protocol"
 	"github.com/v2rayA/shadowsocksR/ssr"
+	cipher2 "github.com/v2rayA/shadowsocksR/streamCipher"
 )
 
+// defaultDialTimeout is the dial timeout of NewSSRClient if the config has none.
+const defaultDialTimeout = 500 * time.Millisecond
+
+// NewSSRClient dials the server described by u, as parsed by config.FromURL.
 func NewSSRClient(u *url.URL) (*SSTCPConn, error) {
-	query := u.Query()
-	encryptMethod := query.Get("encrypt-method")
-	encryptKey := query.Get("encrypt-key")
-	cipher, err := cipher2.NewStreamCipher(encryptMethod, encryptKey)
+	cfg, err := config.FromURL(u)
 	if err != nil {
 		return nil, err
 	}
+	return NewSSRClientFromConfig(cfg)
+}
+
+// NewSSRClientFromConfig validates cfg and dials its server.
+func NewSSRClientFromConfig(cfg *config.Config) (*SSTCPConn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.SetDefaults()
+	if c.DialTimeout == 0 {
+		c.DialTimeout = defaultDialTimeout
+	}
 
 	dialer := net.Dialer{
-		Timeout:   time.Millisecond * 500,
+		Timeout:   c.DialTimeout,
 		DualStack: true,
 	}
-	conn, err := dialer.Dial("tcp", u.Host)
+	conn, err := dialer.Dial("tcp", c.Addr())
+	if err != nil {
+		return nil, &ssr.DialError{Addr: c.Addr(), Err: err}
+	}
+
+	// should initialize obfs/protocol now
+	host, p, err := net.SplitHostPort(conn.RemoteAddr().String())
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	port, _ := strconv.Atoi(p)
+	return NewSSTCPConnFromConfig(conn, &c, host, uint16(port))
+}
+
+// NewSSTCPConnFromConfig wraps conn, a connection to the server of cfg, with
+// the cipher, obfs and protocol of cfg. host and port are the server address
+// given to obfs and protocol. Server, Port and timeouts of cfg are not used.
+// conn is closed on error.
+func NewSSTCPConnFromConfig(conn net.Conn, cfg *config.Config, host string, port uint16) (*SSTCPConn, error) {
+	c := *cfg
+	c.SetDefaults()
+	if cipher2.CheckCipherMethod(c.Method) != nil {
+		conn.Close()
+		return nil, &ssr.UnsupportedError{Kind: "method", Name: c.Method}
+	}
+	cipher, err := cipher2.NewStreamCipher(c.Method, c.Password)
+	if err != nil {
+		conn.Close()
+		return nil, &ssr.ConfigError{Field: "password", Err: err}
+	}
 
 	ssconn := NewSSTCPConn(conn, cipher)
 	if ssconn.Conn == nil || ssconn.RemoteAddr() == nil {
 		return nil, errors.New("nil connection")
 	}
 
-	// should initialize obfs/protocol now
-	rs := strings.Split(ssconn.RemoteAddr().String(), ":")
-	port, _ := strconv.Atoi(rs[1])
-
-	ssconn.IObfs = obfs.NewObfs(query.Get("obfs"))
+	ssconn.ObfsName, ssconn.ProtocolName = c.Obfs, c.Protocol
+	ssconn.IObfs = obfs.NewObfs(c.Obfs)
+	if ssconn.IObfs == nil {
+		ssconn.Close()
+		return nil, &ssr.UnsupportedError{Kind: "obfs", Name: c.Obfs}
+	}
 	obfsServerInfo := &ssr.ServerInfo{
-		Host:   rs[0],
-		Port:   uint16(port),
-		TcpMss: 1460,
-		Param:  query.Get("obfs-param"),
+		Host:   host,
+		Port:   port,
+		TcpMss: c.TCPMSS,
+		Param:  c.ObfsParam,
 	}
 	ssconn.IObfs.SetServerInfo(obfsServerInfo)
-	ssconn.IProtocol = protocol.NewProtocol(query.Get("protocol"))
+
+	ssconn.IProtocol = protocol.NewProtocol(c.Protocol)
+	if ssconn.IProtocol == nil {
+		ssconn.Close()
+		return nil, &ssr.UnsupportedError{Kind: "protocol", Name: c.Protocol}
+	}
 	protocolServerInfo := &ssr.ServerInfo{
-		Host:   rs[0],
-		Port:   uint16(port),
-		TcpMss: 1460,
-		Param:  query.Get("protocol-param"),
+		Host:   host,
+		Port:   port,
+		TcpMss: c.TCPMSS,
+		Param:  c.ProtocolParam,
 	}
 	ssconn.IProtocol.SetServerInfo(protocolServerInfo)
 
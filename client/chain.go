@@ -0,0 +1,106 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"golang.org/x/net/proxy"
+)
+
+// HopError is returned by a Chain when one of its hops fails.
+type HopError struct {
+	// Hop is the 1-based position of the failing hop in the chain.
+	Hop int
+	// URL is the URL of the failing hop, without its password.
+	URL string
+	Err error
+}
+
+func (e *HopError) Error() string {
+	return fmt.Sprintf("[chain] hop %d (%s): %v", e.Hop, e.URL, e.Err)
+}
+
+func (e *HopError) Unwrap() error {
+	return e.Err
+}
+
+// Chain is a dialer that goes through several proxies in order, each hop
+// dialing the next one through the previous hops.
+type Chain struct {
+	hops []proxy.Dialer
+}
+
+// NewChain returns a chain of the proxies in urls, in the order they are
+// traversed. The first hop is dialed with d. Supported schemes are ssr,
+// socks5 and http (CONNECT).
+func NewChain(urls []string, d proxy.Dialer, log *logrus.Logger) (*Chain, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("[chain] no hop")
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	c := &Chain{}
+	for i, s := range urls {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, &HopError{Hop: i + 1, URL: "", Err: fmt.Errorf("parse err: %w", err)}
+		}
+		var hop proxy.Dialer
+		switch u.Scheme {
+		case "ssr":
+			hop, err = NewSSR(s, d, log)
+		case "socks5":
+			var auth *proxy.Auth
+			if u.User != nil {
+				auth = &proxy.Auth{User: u.User.Username()}
+				auth.Password, _ = u.User.Password()
+			}
+			hop, err = proxy.SOCKS5("tcp", u.Host, auth, d)
+		case "http":
+			hop, err = NewHTTP(s, d)
+		default:
+			err = fmt.Errorf("unsupported scheme: %v", u.Scheme)
+		}
+		if err != nil {
+			return nil, &HopError{Hop: i + 1, URL: u.Redacted(), Err: err}
+		}
+		d = &hopDialer{Dialer: hop, hop: i + 1, url: u.Redacted()}
+		c.hops = append(c.hops, d)
+	}
+	return c, nil
+}
+
+// Len returns the number of hops of the chain.
+func (c *Chain) Len() int {
+	return len(c.hops)
+}
+
+// Dial connects to the address addr on the network net through every hop.
+func (c *Chain) Dial(network, addr string) (net.Conn, error) {
+	return c.hops[len(c.hops)-1].Dial(network, addr)
+}
+
+// hopDialer attributes the errors of a hop to it, unless they were already
+// attributed to a previous hop.
+type hopDialer struct {
+	proxy.Dialer
+	hop int
+	url string
+}
+
+func (d *hopDialer) Dial(network, addr string) (net.Conn, error) {
+	c, err := d.Dialer.Dial(network, addr)
+	if err != nil {
+		var he *HopError
+		if errors.As(err, &he) {
+			return nil, he
+		}
+		return nil, &HopError{Hop: d.hop, URL: d.url, Err: err}
+	}
+	return c, nil
+}
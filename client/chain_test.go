@@ -0,0 +1,78 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/inbound"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"golang.org/x/net/proxy"
+)
+
+func TestChain(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	cfg, ssrL := listenSSR(t)
+	defer ssrL.Close()
+	httpL := listenHTTPProxy(t)
+	defer httpL.Close()
+	socksL, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socksL.Close()
+	go inbound.NewSOCKS5(proxy.Direct, nil).Serve(socksL)
+
+	// socks5, then http, then ssr to the echo server
+	urls := []string{
+		"socks5://" + socksL.Addr().String(),
+		"http://user:pass@" + httpL.Addr().String(),
+		cfg.URL().String(),
+	}
+	c, err := NewChain(urls, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 3 {
+		t.Fatalf("%d hops", c.Len())
+	}
+	conn, err := c.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, conn)
+	conn.Close()
+
+	// the failure of the http hop is attributed to it
+	urls[1] = "http://user:wrong@" + httpL.Addr().String()
+	if c, err = NewChain(urls, proxy.Direct, nil); err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Dial("tcp", echo.Addr().String())
+	var he *HopError
+	if !errors.As(err, &he) || he.Hop != 2 {
+		t.Fatalf("expected an error of hop 2, got %v", err)
+	}
+	if u, _ := url.Parse(he.URL); u == nil || u.User.String() != "user:xxxxx" {
+		t.Errorf("the password is not redacted: %v", he.URL)
+	}
+}
+
+func TestNewChain(t *testing.T) {
+	for _, urls := range [][]string{
+		nil,
+		{"ftp://127.0.0.1:21"},
+		{"socks5://127.0.0.1:1080", "ssr://127.0.0.1:8388"},
+	} {
+		if _, err := NewChain(urls, proxy.Direct, nil); err == nil {
+			t.Errorf("%v: expected an error", urls)
+		}
+	}
+	_, err := NewChain([]string{"socks5://127.0.0.1:1080", "vmess://127.0.0.1:443"}, proxy.Direct, nil)
+	var he *HopError
+	if !errors.As(err, &he) || he.Hop != 2 {
+		t.Errorf("expected an error of hop 2, got %v", err)
+	}
+}
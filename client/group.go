@@ -0,0 +1,388 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// Strategy decides which server of a Group is dialed first.
+type Strategy int
+
+const (
+	// RoundRobin dials the servers in turn.
+	RoundRobin Strategy = iota
+	// Random dials a random server.
+	Random
+	// LeastConnections dials the server with the fewest open connections.
+	LeastConnections
+	// LowestLatency dials the server with the lowest measured latency.
+	LowestLatency
+)
+
+var strategyNames = map[Strategy]string{
+	RoundRobin:       "round-robin",
+	Random:           "random",
+	LeastConnections: "least-connections",
+	LowestLatency:    "lowest-latency",
+}
+
+func (s Strategy) String() string {
+	if name, ok := strategyNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Strategy(%d)", int(s))
+}
+
+// ParseStrategy returns the strategy named name, such as "round-robin".
+func ParseStrategy(name string) (Strategy, error) {
+	for s, n := range strategyNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, errors.New("[group] unknown strategy: " + name)
+}
+
+const (
+	// DefaultBackoff is how long a server is skipped after its first failure.
+	// The period doubles with every further consecutive failure.
+	DefaultBackoff = 5 * time.Second
+	// DefaultMaxBackoff is the longest period a failing server is skipped.
+	DefaultMaxBackoff = 5 * time.Minute
+)
+
+// NodeStatus is a snapshot of the state of a server in a Group.
+type NodeStatus struct {
+	Addr      string
+	Conns     int
+	Latency   time.Duration
+	Failures  int
+	Healthy   bool
+	RetryAt   time.Time
+	LastError error
+}
+
+// groupDialer is a server of a Group, implemented by SSR and SS.
+type groupDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+	DialUDP(network, addr string) (net.PacketConn, net.Addr, error)
+	SupportsUDP() bool
+	Addr() string
+}
+
+type node struct {
+	dialer    groupDialer
+	conns     int
+	latency   time.Duration
+	failures  int
+	retryAt   time.Time
+	lastError error
+}
+
+// is reports whether d is the server of n. Servers are told apart by
+// identity, as several may share an address with another obfs or protocol.
+func (n *node) is(d proxy.Dialer) bool {
+	return d != nil && proxy.Dialer(n.dialer) == d
+}
+
+// Group dials through one of several SSR servers. The server is chosen by the
+// strategy of the group. If dialing or the handshake fails, the next server is
+// tried and the failing one is marked unhealthy for a backoff period.
+type Group struct {
+	log      *logrus.Logger
+	strategy Strategy
+	dialers  []*SSR
+
+	mu    sync.Mutex
+	nodes []*node
+	next  int
+
+	// Backoff is how long a server is skipped after its first failure,
+	// DefaultBackoff if zero.
+	Backoff time.Duration
+	// MaxBackoff caps the backoff period, DefaultMaxBackoff if zero.
+	MaxBackoff time.Duration
+}
+
+// NewGroup returns a group dialer over dialers.
+func NewGroup(dialers []*SSR, strategy Strategy, log *logrus.Logger) (*Group, error) {
+	nodes := make([]groupDialer, len(dialers))
+	for i, d := range dialers {
+		nodes[i] = d
+	}
+	g, err := newGroup(nodes, strategy, log)
+	if err != nil {
+		return nil, err
+	}
+	g.dialers = dialers
+	return g, nil
+}
+
+// NewDialerGroup returns a group dialer over the proxies of links, as
+// returned by NewDialer. Its Dialers are the SSR proxies among them.
+func NewDialerGroup(dialers []Dialer, strategy Strategy, log *logrus.Logger) (*Group, error) {
+	nodes := make([]groupDialer, len(dialers))
+	for i, d := range dialers {
+		nodes[i] = d
+	}
+	g, err := newGroup(nodes, strategy, log)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dialers {
+		if s, ok := d.(*SSR); ok {
+			g.dialers = append(g.dialers, s)
+		}
+	}
+	return g, nil
+}
+
+func newGroup(dialers []groupDialer, strategy Strategy, log *logrus.Logger) (*Group, error) {
+	if len(dialers) == 0 {
+		return nil, errors.New("[group] no server")
+	}
+	if _, ok := strategyNames[strategy]; !ok {
+		return nil, fmt.Errorf("[group] unknown strategy: %v", strategy)
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	g := &Group{
+		log:      log,
+		strategy: strategy,
+	}
+	for _, d := range dialers {
+		g.nodes = append(g.nodes, &node{dialer: d})
+	}
+	return g, nil
+}
+
+// Dial connects to the address addr on the network net through one of the servers.
+func (g *Group) Dial(network, addr string) (net.Conn, error) {
+	// a bad address is not a failure of the servers
+	if socks.ParseAddr(addr) == nil {
+		return nil, errors.New("[group] unable to parse address: " + addr)
+	}
+	var errs []string
+	for _, n := range g.candidates() {
+		start := time.Now()
+		c, err := n.dialer.Dial(network, addr)
+		if err != nil {
+			g.fail(n, err)
+			errs = append(errs, err.Error())
+			continue
+		}
+		g.succeed(n, time.Since(start))
+		return &groupConn{Conn: c, group: g, node: n}, nil
+	}
+	return nil, errors.New("[group] all servers failed: " + strings.Join(errs, "; "))
+}
+
+// DialUDP connects to the given address through one of the servers.
+func (g *Group) DialUDP(network, addr string) (net.PacketConn, net.Addr, error) {
+	var errs []string
+	for _, n := range g.candidates() {
+		pc, writeTo, err := n.dialer.DialUDP(network, addr)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return pc, writeTo, nil
+	}
+	return nil, nil, errors.New("[group] all servers failed: " + strings.Join(errs, "; "))
+}
+
+// SupportsUDP reports whether any server of the group can relay UDP.
+func (g *Group) SupportsUDP() bool {
+	for _, n := range g.nodes {
+		if n.dialer.SupportsUDP() {
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns a snapshot of the state of every server in the group.
+func (g *Group) Status() []NodeStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	status := make([]NodeStatus, len(g.nodes))
+	for i, n := range g.nodes {
+		status[i] = NodeStatus{
+			Addr:      n.dialer.Addr(),
+			Conns:     n.conns,
+			Latency:   n.latency,
+			Failures:  n.failures,
+			Healthy:   !now.Before(n.retryAt),
+			RetryAt:   n.retryAt,
+			LastError: n.lastError,
+		}
+	}
+	return status
+}
+
+// Dialers returns the servers of the group.
+func (g *Group) Dialers() []*SSR {
+	return append([]*SSR(nil), g.dialers...)
+}
+
+// ObserveProbe updates the state of the probed server from a health check,
+// so that servers failing their probes are skipped and LowestLatency uses the
+// probed first byte time. It can be used as Prober.OnResult.
+func (g *Group) ObserveProbe(r ProbeResult) {
+	if r.Err != nil {
+		for _, n := range g.nodes {
+			if n.is(r.Node) {
+				g.fail(n, r.Err)
+			}
+		}
+		return
+	}
+	g.SetLatency(r.Node, r.FirstByteTime)
+}
+
+// SetLatency records a latency measured outside the group, such as by a
+// health check, for the server d of the group.
+func (g *Group) SetLatency(d proxy.Dialer, latency time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, n := range g.nodes {
+		if n.is(d) {
+			n.latency = latency
+		}
+	}
+}
+
+// candidates returns every server in the order they should be tried: healthy
+// servers ordered by the strategy, then unhealthy ones by their retry time.
+func (g *Group) candidates() []*node {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var healthy, unhealthy []*node
+	for i := range g.nodes {
+		n := g.nodes[(g.next+i)%len(g.nodes)]
+		if now.Before(n.retryAt) {
+			unhealthy = append(unhealthy, n)
+		} else {
+			healthy = append(healthy, n)
+		}
+	}
+	switch g.strategy {
+	case RoundRobin:
+		g.next = (g.next + 1) % len(g.nodes)
+	case Random:
+		rand.Shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	case LeastConnections:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].conns < healthy[j].conns
+		})
+	case LowestLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	}
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return unhealthy[i].retryAt.Before(unhealthy[j].retryAt)
+	})
+	return append(healthy, unhealthy...)
+}
+
+func (g *Group) succeed(n *node, latency time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n.conns++
+	n.failures = 0
+	n.retryAt = time.Time{}
+	if n.latency == 0 {
+		n.latency = latency
+	} else {
+		// exponentially weighted moving average
+		n.latency = (n.latency*7 + latency) / 8
+	}
+}
+
+func (g *Group) fail(n *node, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n.failures++
+	n.lastError = err
+	backoff, maxBackoff := g.Backoff, g.MaxBackoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	for i := 1; i < n.failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	n.retryAt = time.Now().Add(backoff)
+	g.log.Warnf("[group] %v failed %d time(s), retry after %v: %v", n.dialer.Addr(), n.failures, backoff, err)
+}
+
+func (g *Group) release(n *node) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n.conns--
+}
+
+// groupConn tracks the open connections of a server, and reports a failure if
+// the first read fails, which is how obfs and protocol handshake errors surface.
+// A timeout or io.EOF is not a failure of the server: the destination may
+// just be slow, or close the connection without sending anything.
+type groupConn struct {
+	net.Conn
+	group     *Group
+	node      *node
+	readOnce  sync.Once
+	closeOnce sync.Once
+}
+
+func (c *groupConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.readOnce.Do(func() {
+		if n == 0 && err != nil && !isTimeout(err) && !errors.Is(err, io.EOF) {
+			c.group.fail(c.node, err)
+		}
+	})
+	return
+}
+
+func (c *groupConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.group.release(c.node)
+	})
+	return c.Conn.Close()
+}
+
+// CloseWrite half-closes the connection if the underlying one supports it.
+func (c *groupConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("[group] the connection does not support half-close")
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
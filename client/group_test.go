@@ -0,0 +1,216 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeDialer is a server of a Group whose dials fail with err, and whose
+// connections return readErr from Read.
+type fakeDialer struct {
+	addr    string
+	err     error
+	readErr error
+	dials   int
+}
+
+func (d *fakeDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials++
+	if d.err != nil {
+		return nil, d.err
+	}
+	c, s := net.Pipe()
+	s.Close()
+	return &fakeConn{Conn: c, readErr: d.readErr}, nil
+}
+
+func (d *fakeDialer) DialUDP(network, addr string) (net.PacketConn, net.Addr, error) {
+	return nil, nil, errors.New("udp not supported")
+}
+
+func (d *fakeDialer) SupportsUDP() bool { return false }
+func (d *fakeDialer) Addr() string      { return d.addr }
+
+type fakeConn struct {
+	net.Conn
+	readErr error
+}
+
+func (c *fakeConn) Read(b []byte) (int, error) {
+	return 0, c.readErr
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func newTestGroup(t *testing.T, strategy Strategy, dialers ...*fakeDialer) *Group {
+	nodes := make([]groupDialer, len(dialers))
+	for i, d := range dialers {
+		nodes[i] = d
+	}
+	g, err := newGroup(nodes, strategy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// order returns the addresses of the candidates of g.
+func order(g *Group) []string {
+	var addrs []string
+	for _, n := range g.candidates() {
+		addrs = append(addrs, n.dialer.Addr())
+	}
+	return addrs
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGroupStrategy(t *testing.T) {
+	for _, test := range []struct {
+		strategy Strategy
+		setup    func(g *Group)
+		want     [][]string
+	}{
+		{RoundRobin, nil, [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}}},
+		{LeastConnections, func(g *Group) {
+			g.nodes[0].conns, g.nodes[1].conns, g.nodes[2].conns = 3, 1, 2
+		}, [][]string{{"b", "c", "a"}, {"b", "c", "a"}}},
+		{LowestLatency, func(g *Group) {
+			g.SetLatency(g.nodes[0].dialer, 30*time.Millisecond)
+			g.SetLatency(g.nodes[1].dialer, 20*time.Millisecond)
+			g.SetLatency(g.nodes[2].dialer, 10*time.Millisecond)
+		}, [][]string{{"c", "b", "a"}}},
+		// unhealthy servers come last, by retry time
+		{RoundRobin, func(g *Group) {
+			g.nodes[0].retryAt = time.Now().Add(2 * time.Minute)
+			g.nodes[1].retryAt = time.Now().Add(time.Minute)
+		}, [][]string{{"c", "b", "a"}, {"c", "b", "a"}}},
+	} {
+		g := newTestGroup(t, test.strategy, &fakeDialer{addr: "a"}, &fakeDialer{addr: "b"}, &fakeDialer{addr: "c"})
+		if test.setup != nil {
+			test.setup(g)
+		}
+		for i, want := range test.want {
+			if got := order(g); !equal(got, want) {
+				t.Errorf("%v #%d: got %v, want %v", test.strategy, i, got, want)
+			}
+		}
+	}
+
+	g := newTestGroup(t, Random, &fakeDialer{addr: "a"}, &fakeDialer{addr: "b"}, &fakeDialer{addr: "c"})
+	if got := order(g); len(got) != 3 {
+		t.Errorf("random: got %v", got)
+	}
+	if _, err := newGroup(nil, RoundRobin, nil); err == nil {
+		t.Error("expected an error without server")
+	}
+	if _, err := newGroup([]groupDialer{&fakeDialer{}}, Strategy(42), nil); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestGroupBackoff(t *testing.T) {
+	g := newTestGroup(t, RoundRobin, &fakeDialer{addr: "a"})
+	g.Backoff, g.MaxBackoff = time.Second, 3*time.Second
+	n := g.nodes[0]
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		start := time.Now()
+		g.fail(n, errors.New("failure"))
+		if backoff := n.retryAt.Sub(start); backoff < want || backoff > want+time.Second/2 {
+			t.Errorf("failure %d: backoff %v, want %v", i+1, backoff, want)
+		}
+	}
+	if s := g.Status()[0]; s.Healthy || s.Failures != 4 || s.LastError == nil {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	g.succeed(n, time.Millisecond)
+	if s := g.Status()[0]; !s.Healthy || s.Failures != 0 || s.Conns != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+}
+
+func TestGroupFailover(t *testing.T) {
+	a := &fakeDialer{addr: "a", err: errors.New("connection refused")}
+	b := &fakeDialer{addr: "b"}
+	g := newTestGroup(t, RoundRobin, a, b)
+	c, err := g.Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.(*groupConn).node.dialer != b || a.dials != 1 {
+		t.Fatalf("dialed %v, a %d time(s)", c.(*groupConn).node.dialer.Addr(), a.dials)
+	}
+	if s := g.Status(); s[0].Healthy || !s[1].Healthy || s[1].Conns != 1 {
+		t.Errorf("unexpected status: %+v", s)
+	}
+	c.Close()
+	c.Close()
+	if s := g.Status(); s[1].Conns != 0 {
+		t.Errorf("%d connection(s) after close", s[1].Conns)
+	}
+
+	// the failed server is tried last, and its error is reported
+	b.err = errors.New("no route to host")
+	if _, err = g.Dial("tcp", "example.com:80"); err == nil || b.dials != 2 || a.dials != 2 {
+		t.Fatalf("got %v, dials %d and %d", err, a.dials, b.dials)
+	}
+}
+
+func TestGroupDialInvalidAddress(t *testing.T) {
+	a := &fakeDialer{addr: "a"}
+	g := newTestGroup(t, RoundRobin, a)
+	if _, err := g.Dial("tcp", "no port"); err == nil {
+		t.Fatal("dialed an invalid address")
+	}
+	if s := g.Status()[0]; a.dials != 0 || !s.Healthy || s.Failures != 0 {
+		t.Errorf("the server was blamed for the address: %d dial(s), status %+v", a.dials, s)
+	}
+}
+
+func TestGroupConnRead(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		fail bool
+	}{
+		{io.EOF, false},
+		{timeoutError{}, false},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{errors.New("[ssr] authentication failure"), true},
+	} {
+		d := &fakeDialer{addr: "a", readErr: test.err}
+		g := newTestGroup(t, RoundRobin, d)
+		c, err := g.Dial("tcp", "example.com:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Read(make([]byte, 1))
+		if s := g.Status()[0]; s.Healthy == test.fail {
+			t.Errorf("%v: healthy %v", test.err, s.Healthy)
+		}
+		// only the first read is checked
+		g.succeed(g.nodes[0], time.Millisecond)
+		c.Read(make([]byte, 1))
+		if s := g.Status()[0]; !s.Healthy {
+			t.Errorf("%v: failed on the second read", test.err)
+		}
+		c.Close()
+	}
+}
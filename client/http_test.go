@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/inbound"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"golang.org/x/net/proxy"
+)
+
+// listenHTTPProxy starts an HTTP proxy inbound with basic authentication.
+func listenHTTPProxy(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := inbound.NewHTTP(proxy.Direct, nil)
+	h.Username, h.Password = "user", "pass"
+	go h.Serve(l)
+	return l
+}
+
+func TestHTTP(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	l := listenHTTPProxy(t)
+	defer l.Close()
+
+	h, err := NewHTTP("http://user:pass@"+l.Addr().String(), proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, c)
+	c.Close()
+
+	wrong, err := NewHTTP("http://user:wrong@"+l.Addr().String(), proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := wrong.Dial("tcp", echo.Addr().String()); err == nil || !strings.Contains(err.Error(), "407") {
+		if c != nil {
+			c.Close()
+		}
+		t.Fatalf("expected a 407 error, got %v", err)
+	}
+
+	if h, err := NewHTTP("http://example.com", proxy.Direct); err != nil || h.Addr() != "example.com:80" {
+		t.Errorf("got %v, %v", h, err)
+	}
+	if _, err := NewHTTP("https://example.com", proxy.Direct); err == nil {
+		t.Error("expected an error for https")
+	}
+}
+
+// TestHTTPEarlyData checks that the data sent by the proxy right after its
+// answer is not lost.
+func TestHTTPEarlyData(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil || req.Method != http.MethodConnect || req.Host != "example.com:443" {
+			return
+		}
+		c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\nhello"))
+	}()
+
+	h, err := NewHTTP("http://"+l.Addr().String(), proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := h.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buf := make([]byte, 5)
+	if _, err = c.Read(buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+}
@@ -0,0 +1,316 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// ProbeStage tells which step of a probe failed.
+type ProbeStage int
+
+const (
+	// StageNone means the probe succeeded.
+	StageNone ProbeStage = iota
+	// StageConnect means the TCP connection to the SSR server failed.
+	StageConnect
+	// StageHandshake means the obfs or protocol layer rejected the connection.
+	StageHandshake
+	// StageUpstream means the SSR server was reached but the target did not answer.
+	StageUpstream
+)
+
+func (s ProbeStage) String() string {
+	switch s {
+	case StageNone:
+		return "none"
+	case StageConnect:
+		return "connect"
+	case StageHandshake:
+		return "handshake"
+	case StageUpstream:
+		return "upstream"
+	}
+	return fmt.Sprintf("ProbeStage(%d)", int(s))
+}
+
+const (
+	// DefaultProbeInterval is the period between two probes of a server.
+	DefaultProbeInterval = time.Minute
+	// DefaultProbeTimeout is the timeout of a single probe.
+	DefaultProbeTimeout = 10 * time.Second
+)
+
+// ProbeResult is the outcome of a single probe.
+type ProbeResult struct {
+	// Node is the probed server, and Addr its address.
+	Node *SSR
+	Addr string
+	Time time.Time
+	// ConnectTime is the time taken to open the TCP connection to the server.
+	ConnectTime time.Duration
+	// FirstByteTime is the time from the start of the probe until the first
+	// byte of the answer of the target went through the obfs and protocol.
+	FirstByteTime time.Duration
+	Stage         ProbeStage
+	Err           error
+}
+
+// ProbeStats accumulates the probe results of a server.
+type ProbeStats struct {
+	Node      *SSR
+	Addr      string
+	Last      ProbeResult
+	Total     int
+	Successes int
+	// AvgConnectTime and AvgFirstByteTime average over successful probes.
+	AvgConnectTime   time.Duration
+	AvgFirstByteTime time.Duration
+}
+
+// SuccessRate returns the ratio of successful probes, or 0 if there was none.
+func (s ProbeStats) SuccessRate() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Total)
+}
+
+// Prober periodically sends an HTTP request through SSR servers and measures
+// how long the TCP connection and the first byte of the answer take.
+type Prober struct {
+	log    *logrus.Logger
+	nodes  []*SSR
+	target *url.URL
+	addr   socks.Addr
+
+	// Interval is the period between two probes, DefaultProbeInterval if zero.
+	Interval time.Duration
+	// Timeout is the timeout of a single probe, DefaultProbeTimeout if zero.
+	Timeout time.Duration
+	// OnResult is called after every probe if not nil.
+	OnResult func(r ProbeResult)
+
+	mu    sync.Mutex
+	stats map[*SSR]*ProbeStats
+}
+
+// NewProber returns a prober of nodes that requests target, an http URL such
+// as "http://127.0.0.1:8080/generate_204".
+func NewProber(nodes []*SSR, target string, log *logrus.Logger) (*Prober, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("[probe] parse err: %w", err)
+	}
+	if u.Scheme != "http" {
+		return nil, errors.New("[probe] only http targets are supported: " + target)
+	}
+	addr := socks.ParseAddr(hostPort(u))
+	if addr == nil {
+		return nil, errors.New("[probe] unable to parse address: " + target)
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &Prober{
+		log:    log,
+		nodes:  nodes,
+		target: u,
+		addr:   addr,
+		stats:  make(map[*SSR]*ProbeStats),
+	}, nil
+}
+
+// Run probes every server each Interval until ctx is done.
+func (p *Prober) Run(ctx context.Context) {
+	interval := p.Interval
+	if interval <= 0 {
+		interval = DefaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p.ProbeAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeAll probes every server concurrently and waits for the results.
+func (p *Prober) ProbeAll() []ProbeResult {
+	results := make([]ProbeResult, len(p.nodes))
+	var wg sync.WaitGroup
+	for i, n := range p.nodes {
+		wg.Add(1)
+		go func(i int, n *SSR) {
+			defer wg.Done()
+			results[i] = p.Probe(n)
+		}(i, n)
+	}
+	wg.Wait()
+	return results
+}
+
+// Probe probes s once and records the result.
+func (p *Prober) Probe(s *SSR) ProbeResult {
+	r := p.probe(s)
+	p.record(r)
+	if r.Err != nil {
+		p.log.Warnf("[probe] %v failed at %v: %v", r.Addr, r.Stage, r.Err)
+	} else {
+		p.log.Debugf("[probe] %v connect %v first byte %v", r.Addr, r.ConnectTime, r.FirstByteTime)
+	}
+	if p.OnResult != nil {
+		p.OnResult(r)
+	}
+	return r
+}
+
+// Stats returns the accumulated results of every probed server.
+func (p *Prober) Stats() []ProbeStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProbeStats, 0, len(p.nodes))
+	for _, n := range p.nodes {
+		if st, ok := p.stats[n]; ok {
+			stats = append(stats, *st)
+		}
+	}
+	return stats
+}
+
+func (p *Prober) probe(s *SSR) (r ProbeResult) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProbeTimeout
+	}
+	r.Node, r.Addr = s, s.Addr()
+	r.Time = time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// dial without the warm pool, through a dialer that measures the connect time
+	td := &timingDialer{ctx: ctx, dialer: s.dialer}
+	start := time.Now()
+	ssrconn, err := s.newConn(td)
+	if err != nil {
+		r.Err = err
+		if errors.Is(err, ssr.ErrDial) {
+			r.Stage = StageConnect
+		} else {
+			r.Stage = StageHandshake
+		}
+		return
+	}
+	r.ConnectTime = td.elapsed
+	ssrconn.SetDeadline(start.Add(timeout))
+	c, err := s.connect(ssrconn, p.addr)
+	if err != nil {
+		r.Err, r.Stage = err, classifyProbeError(err)
+		return
+	}
+	defer c.Close()
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        p.target,
+		Host:       p.target.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"User-Agent": {""}},
+		Close:      true,
+	}
+	if err = req.Write(c); err != nil {
+		r.Err, r.Stage = err, classifyProbeError(err)
+		return
+	}
+	br := bufio.NewReader(c)
+	if _, err = br.Peek(1); err != nil {
+		r.Err, r.Stage = err, classifyProbeError(err)
+		return
+	}
+	r.FirstByteTime = time.Since(start)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		r.Err, r.Stage = err, StageUpstream
+		return
+	}
+	resp.Body.Close()
+	return
+}
+
+func (p *Prober) record(r ProbeResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st, ok := p.stats[r.Node]
+	if !ok {
+		st = &ProbeStats{Node: r.Node, Addr: r.Addr}
+		p.stats[r.Node] = st
+	}
+	st.Last = r
+	st.Total++
+	if r.Err != nil {
+		return
+	}
+	st.Successes++
+	n := time.Duration(st.Successes)
+	st.AvgConnectTime += (r.ConnectTime - st.AvgConnectTime) / n
+	st.AvgFirstByteTime += (r.FirstByteTime - st.AvgFirstByteTime) / n
+}
+
+// classifyProbeError tells the stage of err, returned before any byte of the
+// answer went through the obfs and protocol. An error of these layers, or the
+// server closing or resetting the connection, means that the server rejected
+// the connection, such as for a wrong password.
+func classifyProbeError(err error) ProbeStage {
+	if errors.Is(err, ssr.ErrHandshake) || errors.Is(err, ssr.ErrAuth) || errors.Is(err, ssr.ErrProtocol) {
+		return StageHandshake
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) {
+		return StageHandshake
+	}
+	return StageUpstream
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// timingDialer measures how long dialing the SSR server takes.
+type timingDialer struct {
+	ctx     context.Context
+	dialer  proxy.Dialer
+	elapsed time.Duration
+}
+
+func (d *timingDialer) Dial(network, addr string) (c net.Conn, err error) {
+	start := time.Now()
+	if cd, ok := d.dialer.(proxy.ContextDialer); ok {
+		c, err = cd.DialContext(d.ctx, network, addr)
+	} else {
+		c, err = d.dialer.Dial(network, addr)
+	}
+	d.elapsed = time.Since(start)
+	return
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"golang.org/x/net/proxy"
+)
+
+func TestClassifyProbeError(t *testing.T) {
+	for _, test := range []struct {
+		err   error
+		stage ProbeStage
+	}{
+		{&ssr.HandshakeError{Addr: "1.2.3.4:443", Obfs: "tls1.2_ticket_auth", Err: ssr.ErrTLS12TicketAuthIncorrectMagicNumber}, StageHandshake},
+		{&ssr.AuthError{Addr: "1.2.3.4:443", Err: ssr.ErrAuthChainIncorrectHMAC}, StageHandshake},
+		{fmt.Errorf("read: %w", &ssr.ProtocolError{Addr: "1.2.3.4:443", Err: errors.New("bad length")}), StageHandshake},
+		// the server drops the connection before anything was decoded
+		{io.EOF, StageHandshake},
+		{io.ErrUnexpectedEOF, StageHandshake},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, StageHandshake},
+		{timeoutError{}, StageUpstream},
+		{errors.New("something else"), StageUpstream},
+	} {
+		if stage := classifyProbeError(test.err); stage != test.stage {
+			t.Errorf("%v: stage %v, want %v", test.err, stage, test.stage)
+		}
+	}
+}
+
+func TestProberRecord(t *testing.T) {
+	node := &SSR{}
+	p, err := NewProber([]*SSR{node}, "http://127.0.0.1:8080/generate_204", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []ProbeResult{
+		{ConnectTime: 10 * time.Millisecond, FirstByteTime: 100 * time.Millisecond},
+		{Err: io.EOF, Stage: StageHandshake, ConnectTime: time.Second, FirstByteTime: time.Second},
+		{ConnectTime: 20 * time.Millisecond, FirstByteTime: 200 * time.Millisecond},
+		{ConnectTime: 30 * time.Millisecond, FirstByteTime: 600 * time.Millisecond},
+	} {
+		r.Node, r.Addr = node, "1.2.3.4:443"
+		p.record(r)
+	}
+	st := p.stats[node]
+	// failed probes are counted, but not averaged
+	if st.Total != 4 || st.Successes != 3 || st.SuccessRate() != 0.75 {
+		t.Errorf("unexpected counts: %+v", st)
+	}
+	if st.AvgConnectTime != 20*time.Millisecond || st.AvgFirstByteTime != 300*time.Millisecond {
+		t.Errorf("averages %v and %v, want 20ms and 300ms", st.AvgConnectTime, st.AvgFirstByteTime)
+	}
+	if st.Last.ConnectTime != 30*time.Millisecond {
+		t.Errorf("unexpected last result: %+v", st.Last)
+	}
+	if stats := p.Stats(); len(stats) != 1 || stats[0].Node != node {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if (ProbeStats{}).SuccessRate() != 0 {
+		t.Error("success rate without probe")
+	}
+}
+
+func TestNewProber(t *testing.T) {
+	for _, target := range []string{"https://example.com/", "http://example.com:99999/", "%"} {
+		if _, err := NewProber(nil, target, nil); err == nil {
+			t.Errorf("%q: expected an error", target)
+		}
+	}
+	p, err := NewProber(nil, "http://example.com/generate_204", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := p.addr.String(); addr != "example.com:80" {
+		t.Errorf("target address %v", addr)
+	}
+}
+
+// TestProbe probes an SSR server in front of an HTTP server, and a server
+// that is not listening.
+func TestProbe(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	cfg, l := listenSSR(t)
+	defer l.Close()
+	up, err := NewSSRFromConfig(cfg, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := *cfg
+	down.Port = closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	dead, err := NewSSRFromConfig(&down, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProber([]*SSR{up, dead}, target.URL+"/generate_204", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Timeout = 5 * time.Second
+	results := p.ProbeAll()
+	if r := results[0]; r.Err != nil || r.Node != up || r.ConnectTime <= 0 || r.FirstByteTime < r.ConnectTime {
+		t.Errorf("unexpected result of the server: %+v", r)
+	}
+	if r := results[1]; r.Stage != StageConnect || r.Node != dead || r.FirstByteTime != 0 {
+		t.Errorf("unexpected result of the closed port: %+v", r)
+	}
+	stats := p.Stats()
+	if len(stats) != 2 || stats[0].Successes != 1 || stats[1].Successes != 0 || stats[0].AvgFirstByteTime != results[0].FirstByteTime {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// TestObserveProbe checks that the results of a server are not given to
+// another one at the same address.
+func TestObserveProbe(t *testing.T) {
+	a, err := NewSSRFromConfig(&config.Config{Server: "127.0.0.1", Port: 8388, Password: "pw"}, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewSSRFromConfig(&config.Config{Server: "127.0.0.1", Port: 8388, Password: "pw", Protocol: "auth_aes128_md5"}, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGroup([]*SSR{a, b}, LowestLatency, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ObserveProbe(ProbeResult{Node: a, Addr: a.Addr(), FirstByteTime: 50 * time.Millisecond})
+	g.ObserveProbe(ProbeResult{Node: b, Addr: b.Addr(), Err: io.EOF, Stage: StageHandshake})
+	status := g.Status()
+	if !status[0].Healthy || status[0].Latency != 50*time.Millisecond {
+		t.Errorf("unexpected status of the first server: %+v", status[0])
+	}
+	if status[1].Healthy || status[1].Latency != 0 {
+		t.Errorf("unexpected status of the second server: %+v", status[1])
+	}
+}
@@ -0,0 +1,167 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	shadowsocksr "github.com/v2rayA/shadowsocksR"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/plugin"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	cipher "github.com/v2rayA/shadowsocksR/streamCipher"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// SS is a plain Shadowsocks proxy with a stream cipher. It speaks the same
+// protocol as an SSR proxy with the plain obfs and the origin protocol, which
+// its connections use.
+type SS struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+	addr   string
+	plugin *plugin.Plugin
+
+	EncryptMethod   string
+	EncryptPassword string
+	DialTimeout     time.Duration
+}
+
+// NewSSFromConfig validates cfg and returns a Shadowsocks proxy of its server.
+// The obfs of cfg must be plain and its protocol origin.
+func NewSSFromConfig(cfg *config.Config, d proxy.Dialer, log *logrus.Logger) (*SS, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.SetDefaults()
+	if c.Obfs != "plain" || c.Protocol != "origin" {
+		return nil, &ssr.ConfigError{Field: "obfs", Err: fmt.Errorf("obfs %s and protocol %s are not plain Shadowsocks", c.Obfs, c.Protocol)}
+	}
+	if _, err := cipher.NewStreamCipher(c.Method, c.Password); err != nil {
+		return nil, &ssr.ConfigError{Field: "password", Err: err}
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &SS{
+		log:             log,
+		dialer:          d,
+		addr:            c.Addr(),
+		EncryptMethod:   c.Method,
+		EncryptPassword: c.Password,
+		DialTimeout:     c.DialTimeout,
+	}, nil
+}
+
+// Dialer is a proxy returned by NewDialer, an SS or an SSR. Close releases
+// what the proxy holds: the plugin process of an SS, the warm connections
+// of an SSR.
+type Dialer interface {
+	proxy.Dialer
+	DialUDP(network, addr string) (net.PacketConn, net.Addr, error)
+	SupportsUDP() bool
+	Addr() string
+	Close() error
+}
+
+// NewDialer returns the proxy of a link: a Shadowsocks proxy if it has no obfs
+// and protocol, an SSR proxy otherwise. The simple-obfs plugin is replaced by
+// the obfs of the same mode, other plugins are started and connected to
+// directly, without d, until the proxy is closed.
+func NewDialer(l *config.Link, d proxy.Dialer, log *logrus.Logger) (Dialer, error) {
+	if c, ok := l.NativeConfig(); ok {
+		c.SetDefaults()
+		if c.Obfs == "plain" && c.Protocol == "origin" {
+			return NewSSFromConfig(&c, d, log)
+		}
+		return NewSSRFromConfig(&c, d, log)
+	}
+
+	c := l.Config
+	c.SetDefaults()
+
+	s, err := NewSSFromConfig(&c, proxy.Direct, log)
+	if err != nil {
+		return nil, err
+	}
+	p, err := plugin.New(l.Plugin, s.addr, log)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.Start(); err != nil {
+		return nil, err
+	}
+	s.plugin = p
+	return s, nil
+}
+
+// Addr returns forwarder's address
+func (s *SS) Addr() string {
+	return s.addr
+}
+
+// Dial connects to the address addr on the network net via the proxy.
+func (s *SS) Dial(network, addr string) (net.Conn, error) {
+	target := socks.ParseAddr(addr)
+	if target == nil {
+		return nil, errors.New("[ss] unable to parse address: " + addr)
+	}
+	var (
+		c   net.Conn
+		err error
+	)
+	d, serverAddr := s.dialer, s.addr
+	if s.plugin != nil {
+		// the plugin may be restarting
+		d, serverAddr = proxy.Direct, s.plugin.LocalAddr()
+		c, err = s.plugin.Dial(s.DialTimeout)
+	} else {
+		c, err = dialServer(d, serverAddr, s.DialTimeout)
+	}
+	if err != nil {
+		return nil, &ssr.DialError{Addr: serverAddr, Err: err}
+	}
+	host, port, err := serverHostPort(d, c.RemoteAddr(), serverAddr)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	sc, err := shadowsocksr.NewSSTCPConnFromConfig(c, &config.Config{
+		Method:   s.EncryptMethod,
+		Password: s.EncryptPassword,
+		Obfs:     "plain",
+		Protocol: "origin",
+	}, host, port)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Printf("proxy %v <-> %v <-> %v\n", c.LocalAddr(), c.RemoteAddr(), target)
+	if _, err := sc.Write(target); err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+// DialUDP connects to the given address via the proxy.
+func (s *SS) DialUDP(network, addr string) (net.PacketConn, net.Addr, error) {
+	return nil, nil, errors.New("[ss] udp not supported now")
+}
+
+// SupportsUDP reports whether DialUDP can relay UDP.
+func (s *SS) SupportsUDP() bool {
+	return false
+}
+
+// Close stops the plugin of the proxy, if any.
+func (s *SS) Close() error {
+	if s.plugin != nil {
+		return s.plugin.Close()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"golang.org/x/net/proxy"
+)
+
+func TestSS(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	cfg, l := listenSSR(t)
+	defer l.Close()
+
+	link, err := (&config.Link{Config: *cfg}).SSLink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := config.ParseLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDialer(parsed, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.(*SS); !ok {
+		t.Fatalf("expected a plain Shadowsocks dialer, got %T", d)
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testutil.EchoThrough(t, c)
+}
+
+func TestSSPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stub := filepath.Join(dir, "stub")
+	if runtime.GOOS == "windows" {
+		stub += ".exe"
+	}
+	if out, err := exec.Command("go", "build", "-o", stub, "../plugin/testdata/stub").CombinedOutput(); err != nil {
+		t.Skipf("cannot build the stub plugin: %v\n%s", err, out)
+	}
+
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	cfg, l := listenSSR(t)
+	defer l.Close()
+
+	d, err := NewDialer(&config.Link{Config: *cfg, Plugin: stub}, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, c)
+	c.Close()
+
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := d.Dial("tcp", echo.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("dialed through a closed plugin")
+	}
+}
+
+func TestSSDialInvalidAddress(t *testing.T) {
+	s, err := NewSSFromConfig(&config.Config{Server: "127.0.0.1", Port: 8388, Password: "pw"}, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Dial("tcp", "no port"); err == nil || errors.Is(err, ssr.ErrDial) {
+		t.Fatalf("expected an address error, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/server"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"golang.org/x/net/proxy"
+)
+
+// listenSSR starts an SSR server and returns its config.
+func listenSSR(t *testing.T) (*config.Config, net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Server: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, Method: "chacha20-ietf", Password: "Alice's secret"}
+	s, err := server.New(cfg, proxy.Direct, nil)
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	return cfg, l
+}
+
+func TestServerHostPort(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8388}
+	hop, err := NewHTTP("http://10.0.0.1:8388", proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		d    proxy.Dialer
+		addr string
+		host string
+	}{
+		{proxy.Direct, "example.com:8388", "10.0.0.1"},
+		{&net.Dialer{}, "example.com:8388", "10.0.0.1"},
+		// the remote address is the one of the hop, on the same port
+		{hop, "example.com:8388", "example.com"},
+		{&hopDialer{Dialer: hop, hop: 1}, "example.com:8388", "example.com"},
+	} {
+		host, port, err := serverHostPort(test.d, remote, test.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != test.host || port != 8388 {
+			t.Errorf("%T: got %v:%v, want %v:8388", test.d, host, port, test.host)
+		}
+	}
+	for _, addr := range []string{"example.com", "example.com:http"} {
+		if _, _, err := serverHostPort(proxy.Direct, remote, addr); err == nil {
+			t.Errorf("%v: expected an error", addr)
+		}
+	}
+}
+
+func TestSSRDialInvalidAddress(t *testing.T) {
+	s, err := NewSSRFromConfig(&config.Config{Server: "127.0.0.1", Port: 8388, Method: "chacha20-ietf", Password: "pw"}, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the server is not to blame for a bad target
+	if _, err = s.Dial("tcp", "no port"); err == nil || errors.Is(err, ssr.ErrDial) {
+		t.Fatalf("got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	shadowsocksr "github.com/v2rayA/shadowsocksR"
+	"github.com/v2rayA/shadowsocksR/obfs"
+)
+
+const (
+	// DefaultWarmIdleTimeout is how long a pre-warmed connection is kept
+	// unused. It is shorter than the usual idle timeout of SSR servers.
+	DefaultWarmIdleTimeout = time.Minute
+
+	warmHandshakeTimeout = 10 * time.Second
+	warmMaxBackoff       = time.Minute
+)
+
+// Warm keeps size connections to the server opened in advance, with their
+// obfs handshake done if it does not depend on the payload, as for
+// tls1.2_ticket_auth. Dial takes one of them and binds it to its destination
+// with the first write. Connections unused for idleTimeout are closed,
+// DefaultWarmIdleTimeout if zero. Calling Warm again replaces the pool, and a
+// size of zero disables it.
+func (s *SSR) Warm(size int, idleTimeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.warm != nil {
+		s.warm.close()
+		s.warm = nil
+	}
+	if size <= 0 {
+		return
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultWarmIdleTimeout
+	}
+	// initialize the shared obfs data now, as connections are created concurrently
+	if s.ObfsData == nil {
+		if o := obfs.NewObfs(s.Obfs); o != nil {
+			s.ObfsData = o.GetData()
+		}
+	}
+	s.warm = newWarmPool(s, size, idleTimeout)
+}
+
+// Close closes the pre-warmed connections.
+func (s *SSR) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.warm != nil {
+		s.warm.close()
+	}
+	return nil
+}
+
+type warmConn struct {
+	conn   *shadowsocksr.SSTCPConn
+	expire time.Time
+}
+
+type warmPool struct {
+	s           *SSR
+	size        int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	conns  []warmConn
+	closed bool
+
+	need chan struct{}
+	die  chan struct{}
+}
+
+func newWarmPool(s *SSR, size int, idleTimeout time.Duration) *warmPool {
+	p := &warmPool{
+		s:           s,
+		size:        size,
+		idleTimeout: idleTimeout,
+		need:        make(chan struct{}, 1),
+		die:         make(chan struct{}),
+	}
+	go p.fill()
+	go p.expire()
+	return p
+}
+
+// get returns a pre-warmed connection, or nil if there is none.
+func (p *warmPool) get() *shadowsocksr.SSTCPConn {
+	defer p.wakeup()
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.conns) > 0 {
+		wc := p.conns[0]
+		p.conns = p.conns[1:]
+		if now.Before(wc.expire) {
+			return wc.conn
+		}
+		wc.conn.Close()
+	}
+	return nil
+}
+
+// put adds c to the pool, or closes it if there is no room for it.
+func (p *warmPool) put(c *shadowsocksr.SSTCPConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.conns) >= p.size {
+		c.Close()
+		return
+	}
+	p.conns = append(p.conns, warmConn{conn: c, expire: time.Now().Add(p.idleTimeout)})
+}
+
+func (p *warmPool) full() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed || len(p.conns) >= p.size
+}
+
+func (p *warmPool) wakeup() {
+	select {
+	case p.need <- struct{}{}:
+	default:
+	}
+}
+
+// fill opens connections until the pool is full, and again whenever some are taken.
+func (p *warmPool) fill() {
+	var backoff time.Duration
+	for {
+		for !p.full() {
+			c, err := p.dial()
+			if err != nil {
+				if backoff == 0 {
+					backoff = time.Second
+				} else if backoff *= 2; backoff > warmMaxBackoff {
+					backoff = warmMaxBackoff
+				}
+				p.s.log.Warnf("[ssr] failed to pre-warm a connection to %v, retry in %v: %v", p.s.addr, backoff, err)
+				select {
+				case <-time.After(backoff):
+					continue
+				case <-p.die:
+					return
+				}
+			}
+			backoff = 0
+			p.put(c)
+		}
+		select {
+		case <-p.need:
+		case <-p.die:
+			return
+		}
+	}
+}
+
+func (p *warmPool) dial() (*shadowsocksr.SSTCPConn, error) {
+	c, err := p.s.newConn(p.s.dialer)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(warmHandshakeTimeout))
+	if err = c.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	c.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// expire closes the connections that have been idle for too long.
+func (p *warmPool) expire() {
+	ticker := time.NewTicker(p.idleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.die:
+			return
+		}
+		now := time.Now()
+		p.mu.Lock()
+		conns := p.conns[:0]
+		for _, wc := range p.conns {
+			if now.Before(wc.expire) {
+				conns = append(conns, wc)
+			} else {
+				wc.conn.Close()
+			}
+		}
+		p.conns = conns
+		p.mu.Unlock()
+		p.wakeup()
+	}
+}
+
+func (p *warmPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.die)
+	for _, wc := range p.conns {
+		wc.conn.Close()
+	}
+	p.conns = nil
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	shadowsocksr "github.com/v2rayA/shadowsocksR"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"github.com/v2rayA/shadowsocksR/obfs"
+)
+
+// listenRandomHead starts a front of the SSR server at upstream that plays
+// the server side of the random_head obfs: it answers the random header, then
+// relays the connection. It counts the completed handshakes.
+func listenRandomHead(t *testing.T, upstream string) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshakes := new(int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				buf := make([]byte, 1024)
+				if _, err := c.Read(buf); err != nil {
+					return
+				}
+				if _, err := c.Write([]byte("random reply")); err != nil {
+					return
+				}
+				atomic.AddInt32(handshakes, 1)
+				rc, err := net.Dial("tcp", upstream)
+				if err != nil {
+					return
+				}
+				defer rc.Close()
+				go io.Copy(rc, c)
+				io.Copy(c, rc)
+			}()
+		}
+	}()
+	return l, handshakes
+}
+
+func TestWarm(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	cfg, ssrL := listenSSR(t)
+	defer ssrL.Close()
+	front, handshakes := listenRandomHead(t, ssrL.Addr().String())
+	defer front.Close()
+
+	c := *cfg
+	c.Port = front.Addr().(*net.TCPAddr).Port
+	c.Obfs = "random_head"
+	s, err := NewSSRFromConfig(&c, &net.Dialer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Warm(1, time.Minute)
+	defer s.Close()
+	for i := 0; atomic.LoadInt32(handshakes) == 0; i++ {
+		if i == 500 {
+			t.Fatal("no connection was pre-warmed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// wait for the pool to take the connection
+	for i := 0; ; i++ {
+		s.warm.mu.Lock()
+		n := len(s.warm.conns)
+		s.warm.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 500 {
+			t.Fatal("the pool is empty")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := s.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// a new connection would still wait for the reply to its random header
+	if !conn.(*shadowsocksr.SSTCPConn).IObfs.(obfs.IHandshaker).HandshakeDone() {
+		t.Fatal("the connection was not pre-warmed")
+	}
+	testutil.EchoThrough(t, conn)
+}
+
+// TestWarmConcurrent replaces the pool while dialing.
+func TestWarmConcurrent(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	cfg, ssrL := listenSSR(t)
+	defer ssrL.Close()
+	s, err := NewSSRFromConfig(cfg, &net.Dialer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			s.Warm(i%2, time.Minute)
+		}(i)
+		go func() {
+			defer wg.Done()
+			conn, err := s.Dial("tcp", echo.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+}
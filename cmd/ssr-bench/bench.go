@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	shadowsocksr "github.com/v2rayA/shadowsocksR"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/server"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+)
+
+const (
+	benchPassword = "ssr-bench"
+	benchTarget   = "example.com:443"
+)
+
+// result is the measurement of a case.
+type result struct {
+	Mode     string `json:"mode"`
+	Method   string `json:"method"`
+	Obfs     string `json:"obfs"`
+	Protocol string `json:"protocol"`
+	// Skipped is the reason the case could not be measured.
+	Skipped string `json:"skipped,omitempty"`
+
+	Bytes           int64   `json:"bytes,omitempty"`
+	Seconds         float64 `json:"seconds,omitempty"`
+	MBPerSecond     float64 `json:"mb_per_second,omitempty"`
+	AllocsPerMB     float64 `json:"allocs_per_mb,omitempty"`
+	AllocBytesPerMB float64 `json:"alloc_bytes_per_mb,omitempty"`
+	// CPUNsPerByte is the CPU time of the process per byte, zero where the
+	// platform does not report it.
+	CPUNsPerByte float64 `json:"cpu_ns_per_byte,omitempty"`
+}
+
+type bench struct {
+	size      int
+	chunk     int
+	timeout   time.Duration
+	transport string
+}
+
+// run measures a case. It returns nil for roundtrip cases that the server
+// does not support.
+func (b *bench) run(mode, method, obfsName, protocolName string) *result {
+	cfg := &config.Config{
+		Server:   "127.0.0.1",
+		Port:     8388,
+		Method:   method,
+		Password: benchPassword,
+		Obfs:     obfsName,
+		Protocol: protocolName,
+	}
+	r := &result{Mode: mode, Method: method, Obfs: obfsName, Protocol: protocolName}
+	var err error
+	if mode == "encode" {
+		err = b.encode(cfg, r)
+	} else {
+		err = b.roundtrip(cfg, r)
+		if errors.Is(err, ssr.ErrUnsupported) {
+			return nil
+		}
+	}
+	if err != nil {
+		r.Skipped = err.Error()
+	}
+	return r
+}
+
+// encode writes through a client connection to a sink.
+func (b *bench) encode(cfg *config.Config, r *result) error {
+	sink := newSinkConn()
+	c, err := newClientConn(sink, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	// the sink answers the handshake with random data, which is enough for
+	// every obfs but those checking a server hello
+	if err = c.Handshake(); err != nil {
+		return err
+	}
+	if _, err = c.Write(socks.ParseAddr(benchTarget)); err != nil {
+		return err
+	}
+	buf := make([]byte, b.chunk)
+	rand.Read(buf)
+	return measure(r, roundUp(b.size, len(buf)), func() error {
+		for n := 0; n < b.size; n += len(buf) {
+			if _, err := c.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// roundtrip writes through a client and a server to an echo destination and
+// reads the data back.
+func (b *bench) roundtrip(cfg *config.Config, r *result) error {
+	srv, err := server.New(cfg, echoDialer{}, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		srv.Shutdown(ctx)
+		cancel()
+	}()
+
+	var conn net.Conn
+	if b.transport == "pipe" {
+		var sc net.Conn
+		conn, sc = net.Pipe()
+		go func() {
+			srv.ServeConn(sc)
+			sc.Close()
+		}()
+	} else {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		go srv.Serve(l)
+		if conn, err = net.Dial("tcp", l.Addr().String()); err != nil {
+			return err
+		}
+	}
+	c, err := newClientConn(conn, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(b.timeout))
+	if _, err = c.Write(socks.ParseAddr(benchTarget)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, b.chunk)
+	rand.Read(buf)
+	size := roundUp(b.size, len(buf))
+	return measure(r, size, func() error {
+		writeErr := make(chan error, 1)
+		go func() {
+			for n := 0; n < b.size; n += len(buf) {
+				if _, err := c.Write(buf); err != nil {
+					writeErr <- err
+					return
+				}
+			}
+			writeErr <- nil
+		}()
+		if _, err := io.CopyN(ioutil.Discard, c, int64(size)); err != nil {
+			return err
+		}
+		return <-writeErr
+	})
+}
+
+// newClientConn wraps conn as the client of cfg, with the obfs and protocol
+// data that client.SSR shares between its connections.
+func newClientConn(conn net.Conn, cfg *config.Config) (*shadowsocksr.SSTCPConn, error) {
+	c, err := shadowsocksr.NewSSTCPConnFromConfig(conn, cfg, cfg.Server, uint16(cfg.Port))
+	if err != nil {
+		return nil, err
+	}
+	c.IObfs.SetData(c.IObfs.GetData())
+	c.IProtocol.SetData(c.IProtocol.GetData())
+	return c, nil
+}
+
+// measure runs f, which transfers size bytes of payload, and records its
+// cost in r.
+func measure(r *result, size int, f func() error) error {
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cpuBefore := cpuTime()
+	start := time.Now()
+	if err := f(); err != nil {
+		return err
+	}
+	elapsed := time.Since(start)
+	cpu := cpuTime() - cpuBefore
+	runtime.ReadMemStats(&after)
+
+	mb := float64(size) / (1 << 20)
+	r.Bytes = int64(size)
+	r.Seconds = elapsed.Seconds()
+	r.MBPerSecond = mb / elapsed.Seconds()
+	r.AllocsPerMB = float64(after.Mallocs-before.Mallocs) / mb
+	r.AllocBytesPerMB = float64(after.TotalAlloc-before.TotalAlloc) / mb
+	if cpu > 0 {
+		r.CPUNsPerByte = float64(cpu.Nanoseconds()) / float64(size)
+	}
+	return nil
+}
+
+// roundUp returns size rounded up to a multiple of chunk.
+func roundUp(size, chunk int) int {
+	return (size + chunk - 1) / chunk * chunk
+}
+
+// sinkConn discards what is written to it. Its first read returns random
+// data, as a reply to the obfs handshake, and later reads block until it is
+// closed.
+type sinkConn struct {
+	mu      sync.Mutex
+	replied bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newSinkConn() *sinkConn {
+	return &sinkConn{done: make(chan struct{})}
+}
+
+func (c *sinkConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	replied := c.replied
+	c.replied = true
+	c.mu.Unlock()
+	if !replied {
+		n := len(b)
+		if n > 64 {
+			n = 64
+		}
+		rand.Read(b[:n])
+		return n, nil
+	}
+	<-c.done
+	return 0, io.EOF
+}
+
+func (c *sinkConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *sinkConn) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+func (c *sinkConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func (c *sinkConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8388}
+}
+
+func (c *sinkConn) SetDeadline(t time.Time) error      { return nil }
+func (c *sinkConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *sinkConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// echoDialer connects the server to an in-memory echo destination.
+type echoDialer struct{}
+
+func (echoDialer) Dial(network, addr string) (net.Conn, error) {
+	c, e := net.Pipe()
+	go func() {
+		io.Copy(e, e)
+		e.Close()
+	}()
+	return c, nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "time"
+
+// cpuTime is not reported on this platform.
+func cpuTime() time.Duration {
+	return 0
+}
@@ -0,0 +1,181 @@
+// Command ssr-bench measures the throughput, allocations and CPU time of every
+// combination of encryption method, obfs and protocol.
+//
+// The encode mode writes through a client connection to a sink that discards
+// the data, which covers the protocol, cipher and obfs of the sending side.
+// Obfs whose handshake needs a real server reply are reported as skipped.
+// The roundtrip mode runs a client and a server over net.Pipe or loopback TCP
+// and echoes the data back, so every byte is encoded and decoded twice. It
+// only covers the combinations supported on the server side, which are the
+// plain obfs and the origin protocol.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/obfs"
+	"github.com/v2rayA/shadowsocksR/protocol"
+	"github.com/v2rayA/shadowsocksR/streamCipher"
+)
+
+type options struct {
+	mode      string
+	transport string
+	methods   string
+	obfs      string
+	protocols string
+	size      int
+	chunk     int
+	timeout   time.Duration
+
+	json       bool
+	cpuProfile string
+	memProfile string
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.mode, "mode", "all", "encode, roundtrip or all")
+	flag.StringVar(&o.transport, "transport", "pipe", "transport of the roundtrip mode: pipe or tcp")
+	flag.StringVar(&o.methods, "m", "", "comma separated encryption methods, all if empty")
+	flag.StringVar(&o.obfs, "o", "", "comma separated obfs, all if empty")
+	flag.StringVar(&o.protocols, "O", "", "comma separated protocols, all if empty")
+	flag.IntVar(&o.size, "size", 4<<20, "bytes written in each case")
+	flag.IntVar(&o.chunk, "chunk", 16<<10, "size of each write")
+	flag.DurationVar(&o.timeout, "timeout", time.Minute, "time limit of each case")
+	flag.BoolVar(&o.json, "json", false, "print JSON instead of a table")
+	flag.StringVar(&o.cpuProfile, "cpuprofile", "", "write a CPU profile of the whole run to this file")
+	flag.StringVar(&o.memProfile, "memprofile", "", "write a heap profile to this file at the end of the run")
+	flag.Parse()
+
+	if o.size <= 0 || o.chunk <= 0 {
+		log.Fatal("size and chunk must be positive")
+	}
+	var modes []string
+	switch o.mode {
+	case "encode", "roundtrip":
+		modes = []string{o.mode}
+	case "all":
+		modes = []string{"encode", "roundtrip"}
+	default:
+		log.Fatalf("unknown mode: %v", o.mode)
+	}
+	if o.transport != "pipe" && o.transport != "tcp" {
+		log.Fatalf("unknown transport: %v", o.transport)
+	}
+	methods, err := selectNames(o.methods, streamCipher.Methods())
+	if err != nil {
+		log.Fatal(err)
+	}
+	obfsNames, err := selectNames(o.obfs, obfs.Names())
+	if err != nil {
+		log.Fatal(err)
+	}
+	protocols, err := selectNames(o.protocols, protocol.Names())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if o.cpuProfile != "" {
+		f, err := os.Create(o.cpuProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = pprof.StartCPUProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		}()
+	}
+
+	b := &bench{size: o.size, chunk: o.chunk, timeout: o.timeout, transport: o.transport}
+	var results []*result
+	for _, mode := range modes {
+		for _, method := range methods {
+			for _, obfsName := range obfsNames {
+				for _, protocolName := range protocols {
+					r := b.run(mode, method, obfsName, protocolName)
+					if r != nil {
+						results = append(results, r)
+					}
+				}
+			}
+		}
+	}
+
+	if o.json {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	} else {
+		err = printTable(results)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if o.memProfile != "" {
+		f, err := os.Create(o.memProfile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		runtime.GC()
+		if err = pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal(err)
+		}
+		f.Close()
+	}
+}
+
+// selectNames returns the names of the comma separated list s, or all if s is
+// empty. Every name must be one of all.
+func selectNames(s string, all []string) ([]string, error) {
+	if s == "" {
+		return all, nil
+	}
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		found := false
+		for _, n := range all {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown name %q, expected one of %v", name, strings.Join(all, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func printTable(results []*result) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MODE\tMETHOD\tOBFS\tPROTOCOL\tMB/s\tALLOCS/MB\tKB ALLOC/MB\tCPU ns/B\tNOTE")
+	for _, r := range results {
+		if r.Skipped != "" {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t-\t-\t-\t-\t%s\n", r.Mode, r.Method, r.Obfs, r.Protocol, r.Skipped)
+			continue
+		}
+		cpu := "-"
+		if r.CPUNsPerByte > 0 {
+			cpu = fmt.Sprintf("%.2f", r.CPUNsPerByte)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.1f\t%.0f\t%.0f\t%s\t\n", r.Mode, r.Method, r.Obfs, r.Protocol,
+			r.MBPerSecond, r.AllocsPerMB, r.AllocBytesPerMB/1024, cpu)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,363 @@
+// Command ssr-link decodes, validates and converts ssr:// and ss:// links.
+//
+// Its arguments are links, subscription files, subscription URLs or - for
+// the standard input, which is also read when there is no argument. A
+// subscription is one link per line, either as is or base64 encoded as a
+// whole.
+//
+// The -f flag selects the output: a readable text (default), JSON, ssr://
+// or ss:// links (one per line, ready to be encoded as QR codes), a
+// shadowsocksr JSON config file, a Clash proxies list or sing-box outbounds.
+// Servers with an obfs or a protocol have no ss:// link and are skipped. A
+// config file holds the ports of a single server, so -f config fails for the
+// links of several servers.
+//
+// The obfs-local plugin of ss:// links is converted to the simple_obfs obfs;
+// links with other plugins are reported as not supported.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+// fetchTimeout bounds the download of a subscription.
+const fetchTimeout = 30 * time.Second
+
+// entry is a parsed link, its config with the plugin converted, and the
+// problems found by validation.
+type entry struct {
+	source string
+	link   *config.Link
+	config config.Config
+	errs   []error
+}
+
+func main() {
+	format := flag.String("f", "text", "output format: text, json, link, ss, config, clash or singbox")
+	validate := flag.Bool("validate", false, "exit with status 1 if a link is malformed or not supported")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [link | file | url | -]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	var (
+		entries []*entry
+		failed  bool
+	)
+	for _, arg := range args {
+		links, err := read(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			failed = true
+		}
+		for _, l := range links {
+			e := &entry{source: arg, link: l}
+			if err := l.Validate(); err != nil {
+				var errs config.Errors
+				if errors.As(err, &errs) {
+					e.errs = errs
+				} else {
+					e.errs = []error{err}
+				}
+			}
+			c, ok := l.NativeConfig()
+			if !ok {
+				e.errs = append(e.errs, &ssr.UnsupportedError{Kind: "plugin", Name: pluginName(l.Plugin)})
+			}
+			e.config = c
+			if len(e.errs) > 0 {
+				failed = true
+			}
+			entries = append(entries, e)
+		}
+	}
+
+	var err error
+	switch *format {
+	case "text":
+		printText(os.Stdout, entries)
+	case "json":
+		err = printJSON(os.Stdout, entries)
+	case "link":
+		for _, e := range valid(entries) {
+			fmt.Println(e.link.SSRLink())
+		}
+	case "ss":
+		for _, e := range valid(entries) {
+			link, err := e.link.SSLink()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "skipping %s: %v\n", name(e.link), err)
+				continue
+			}
+			fmt.Println(link)
+		}
+	case "config":
+		err = printConfig(os.Stdout, valid(entries))
+	case "clash":
+		err = printClash(os.Stdout, valid(entries))
+	case "singbox":
+		err = printSingBox(os.Stdout, valid(entries))
+	default:
+		err = fmt.Errorf("unknown format: %s", *format)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if failed && *validate {
+		os.Exit(1)
+	}
+}
+
+// read returns the links of arg, which is a link, a file, a URL or -.
+func read(arg string) ([]*config.Link, error) {
+	if strings.HasPrefix(arg, "ssr://") || strings.HasPrefix(arg, "ss://") {
+		l, err := config.ParseLink(arg)
+		if err != nil {
+			return nil, err
+		}
+		return []*config.Link{l}, nil
+	}
+	var (
+		b   []byte
+		err error
+	)
+	switch {
+	case arg == "-":
+		b, err = ioutil.ReadAll(os.Stdin)
+	case strings.HasPrefix(arg, "http://") || strings.HasPrefix(arg, "https://"):
+		b, err = fetch(arg)
+	default:
+		b, err = ioutil.ReadFile(arg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config.ParseSubscription(b)
+}
+
+func fetch(url string) ([]byte, error) {
+	c := &http.Client{Timeout: fetchTimeout}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// valid returns the entries without problems, and reports the others.
+func valid(entries []*entry) []*entry {
+	var ok []*entry
+	for _, e := range entries {
+		if len(e.errs) > 0 {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", name(e.link), config.Errors(e.errs))
+			continue
+		}
+		ok = append(ok, e)
+	}
+	return ok
+}
+
+// pluginName returns the name of a plugin, without its options.
+func pluginName(plugin string) string {
+	return strings.TrimSpace(strings.SplitN(plugin, ";", 2)[0])
+}
+
+// name returns the remarks of l, or its server address.
+func name(l *config.Link) string {
+	if l.Remarks != "" {
+		return l.Remarks
+	}
+	return l.Addr()
+}
+
+// uniqueNames returns the names of entries, numbered where they repeat, as
+// Clash and sing-box require unique names.
+func uniqueNames(entries []*entry) []string {
+	names := make([]string, len(entries))
+	seen := make(map[string]int)
+	for i, e := range entries {
+		n := name(e.link)
+		seen[n]++
+		if seen[n] > 1 {
+			n += " " + strconv.Itoa(seen[n])
+		}
+		names[i] = n
+	}
+	return names
+}
+
+func printText(w io.Writer, entries []*entry) {
+	for i, e := range entries {
+		c := e.link.Config
+		c.SetDefaults()
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "[%d] %s\n", i+1, name(e.link))
+		if e.link.Group != "" {
+			fmt.Fprintf(w, "  group:    %s\n", e.link.Group)
+		}
+		fmt.Fprintf(w, "  server:   %s\n", c.Addr())
+		fmt.Fprintf(w, "  method:   %s\n", c.Method)
+		fmt.Fprintf(w, "  password: %s\n", c.Password)
+		fmt.Fprintf(w, "  obfs:     %s\n", withParam(c.Obfs, c.ObfsParam))
+		fmt.Fprintf(w, "  protocol: %s\n", withParam(c.Protocol, c.ProtocolParam))
+		if e.link.Plugin != "" {
+			fmt.Fprintf(w, "  plugin:   %s\n", e.link.Plugin)
+		}
+		if len(e.errs) == 0 {
+			fmt.Fprintln(w, "  status:   ok")
+			continue
+		}
+		for _, err := range e.errs {
+			fmt.Fprintf(w, "  error:    %v\n", err)
+		}
+	}
+}
+
+func withParam(name, param string) string {
+	if param == "" {
+		return name
+	}
+	return name + " (" + param + ")"
+}
+
+func printJSON(w io.Writer, entries []*entry) error {
+	type jsonLink struct {
+		Source        string   `json:"source"`
+		Remarks       string   `json:"remarks,omitempty"`
+		Group         string   `json:"group,omitempty"`
+		Server        string   `json:"server"`
+		Port          int      `json:"server_port"`
+		Method        string   `json:"method"`
+		Password      string   `json:"password"`
+		Obfs          string   `json:"obfs"`
+		ObfsParam     string   `json:"obfs_param,omitempty"`
+		Protocol      string   `json:"protocol"`
+		ProtocolParam string   `json:"protocol_param,omitempty"`
+		Plugin        string   `json:"plugin,omitempty"`
+		Valid         bool     `json:"valid"`
+		Errors        []string `json:"errors,omitempty"`
+	}
+	out := make([]jsonLink, 0, len(entries))
+	for _, e := range entries {
+		c := e.link.Config
+		c.SetDefaults()
+		j := jsonLink{
+			Source:        e.source,
+			Remarks:       e.link.Remarks,
+			Group:         e.link.Group,
+			Server:        c.Server,
+			Port:          c.Port,
+			Method:        c.Method,
+			Password:      c.Password,
+			Obfs:          c.Obfs,
+			ObfsParam:     c.ObfsParam,
+			Protocol:      c.Protocol,
+			ProtocolParam: c.ProtocolParam,
+			Plugin:        e.link.Plugin,
+			Valid:         len(e.errs) == 0,
+		}
+		for _, err := range e.errs {
+			j.Errors = append(j.Errors, err.Error())
+		}
+		out = append(out, j)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// printConfig writes the entries as a config file, which has a single server
+// and one port per entry.
+func printConfig(w io.Writer, entries []*entry) error {
+	cfgs := make([]*config.Config, len(entries))
+	var servers []string
+	for i, e := range entries {
+		c := e.config
+		c.SetDefaults()
+		cfgs[i] = &c
+		if !contains(servers, c.Server) {
+			servers = append(servers, c.Server)
+		}
+	}
+	if len(servers) > 1 {
+		return fmt.Errorf("a config file has a single server, the links have %d: %s; select the links of one server or use another format",
+			len(servers), strings.Join(servers, ", "))
+	}
+	f, err := config.NewFile(cfgs...)
+	if err != nil {
+		return err
+	}
+	return f.WriteJSON(w)
+}
+
+func printClash(w io.Writer, entries []*entry) error {
+	names := uniqueNames(entries)
+	var proxies []*config.ClashProxy
+	for i, e := range entries {
+		p, err := config.ToClash(&e.config, names[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", names[i], err)
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	b, err := config.MarshalClash(proxies)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func printSingBox(w io.Writer, entries []*entry) error {
+	names := uniqueNames(entries)
+	var outbounds []*config.SingBoxOutbound
+	for i, e := range entries {
+		o, err := config.ToSingBox(&e.config, names[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", names[i], err)
+			continue
+		}
+		outbounds = append(outbounds, o)
+	}
+	b, err := config.MarshalSingBox(outbounds)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
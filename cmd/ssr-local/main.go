@@ -0,0 +1,363 @@
+// Command ssr-local runs local SOCKS5 and HTTP proxies that forward
+// connections through SSR servers.
+//
+// The servers come from a shadowsocksr JSON config file (-c), ssr:// or ss://
+// links (-L) or flags. Several servers are load balanced with -strategy. On
+// SIGHUP the config file is read again and new connections use the new
+// servers; listen addresses are only read at startup.
+//
+// With -tls, the SSR connections run inside TLS connections to the servers,
+// verified with the system CAs, -tls-ca or the certificate hashes of -tls-pin.
+// With -ws-path, the servers are connected through WebSocket, possibly behind
+// an HTTP reverse proxy or CDN: -ws-host sets the Host header and -ws-header
+// adds request headers. WebSocket runs inside TLS when both are set.
+//
+// The obfs-local plugin of ss:// links is run natively; other SIP003 plugins
+// are started as processes, which connect to the server themselves, so they
+// cannot be combined with -tls or -ws-path.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/client"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/inbound"
+	"github.com/v2rayA/shadowsocksR/transport"
+	"golang.org/x/net/proxy"
+)
+
+type options struct {
+	configFile string
+	links      string
+	strategy   string
+
+	server        string
+	port          int
+	method        string
+	password      string
+	obfs          string
+	obfsParam     string
+	protocol      string
+	protocolParam string
+
+	tls      bool
+	tlsSNI   string
+	tlsALPN  string
+	tlsCA    string
+	tlsPins  string
+	wsPath   string
+	wsHost   string
+	wsHeader headerFlag
+
+	localAddr string
+	localPort int
+	httpAddr  string
+	localUser string
+	localPass string
+	logLevel  string
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.configFile, "c", "", "shadowsocksr JSON config file, reloaded on SIGHUP")
+	flag.StringVar(&o.links, "L", "", "comma separated ssr:// or ss:// links")
+	flag.StringVar(&o.strategy, "strategy", client.RoundRobin.String(), "load balancing strategy of several servers: round-robin, random, least-connections or lowest-latency")
+	flag.StringVar(&o.server, "s", "", "server address")
+	flag.IntVar(&o.port, "p", 8388, "server port")
+	flag.StringVar(&o.method, "m", config.DefaultMethod, "encrypt method")
+	flag.StringVar(&o.password, "k", "", "password")
+	flag.StringVar(&o.obfs, "o", config.DefaultObfs, "obfs")
+	flag.StringVar(&o.obfsParam, "g", "", "obfs param")
+	flag.StringVar(&o.protocol, "O", config.DefaultProtocol, "protocol")
+	flag.StringVar(&o.protocolParam, "G", "", "protocol param")
+	flag.BoolVar(&o.tls, "tls", false, "connect the servers over TLS")
+	flag.StringVar(&o.tlsSNI, "tls-sni", "", "TLS server name, the server address if empty")
+	flag.StringVar(&o.tlsALPN, "tls-alpn", "", "comma separated ALPN protocols offered to the servers")
+	flag.StringVar(&o.tlsCA, "tls-ca", "", "PEM file of the CAs of the servers, the system CAs if empty")
+	flag.StringVar(&o.tlsPins, "tls-pin", "", "comma separated SHA-256 hashes of accepted server certificates, instead of CA verification")
+	flag.StringVar(&o.wsPath, "ws-path", "", "WebSocket path of the servers, raw TCP if empty")
+	flag.StringVar(&o.wsHost, "ws-host", "", "WebSocket Host header, the server address if empty")
+	flag.Var(&o.wsHeader, "ws-header", "extra WebSocket request header, as \"Key: Value\", may be repeated")
+	flag.StringVar(&o.localAddr, "b", "127.0.0.1", "local address of the SOCKS5 proxy")
+	flag.IntVar(&o.localPort, "l", 0, "local port of the SOCKS5 proxy, local_port of the config file or 1080 if zero")
+	flag.StringVar(&o.httpAddr, "http", "", "listen address of the HTTP proxy, disabled if empty")
+	flag.StringVar(&o.localUser, "user", "", "username required by the local proxies, none if empty")
+	flag.StringVar(&o.localPass, "pass", "", "password required by the local proxies")
+	flag.StringVar(&o.logLevel, "log-level", "info", "log level: panic, fatal, error, warn, info, debug or trace")
+	flag.Parse()
+
+	log := logrus.New()
+	level, err := logrus.ParseLevel(o.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(level)
+
+	d := &swapDialer{}
+	file, err := o.load(d, log)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	localPort := o.localPort
+	if localPort == 0 && file != nil {
+		localPort = file.LocalPort
+	}
+	if localPort == 0 {
+		localPort = 1080
+	}
+	socksAddr := net.JoinHostPort(o.localAddr, strconv.Itoa(localPort))
+
+	errCh := make(chan error, 2)
+	socks5 := inbound.NewSOCKS5(d, log)
+	socks5.Username, socks5.Password = o.localUser, o.localPass
+	go func() {
+		log.Infof("SOCKS5 proxy listening on %v", socksAddr)
+		errCh <- socks5.ListenAndServe(socksAddr)
+	}()
+	if o.httpAddr != "" {
+		h := inbound.NewHTTP(d, log)
+		h.Username, h.Password = o.localUser, o.localPass
+		go func() {
+			log.Infof("HTTP proxy listening on %v", o.httpAddr)
+			errCh <- h.ListenAndServe(o.httpAddr)
+		}()
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-errCh:
+			d.close()
+			log.Fatal(err)
+		case sig := <-sigCh:
+			if sig != syscall.SIGHUP {
+				log.Infof("received %v, exiting", sig)
+				d.close()
+				return
+			}
+			if o.configFile == "" {
+				log.Warn("received SIGHUP, but there is no config file to reload")
+				continue
+			}
+			if _, err := o.load(d, log); err != nil {
+				log.Errorf("failed to reload %v, keeping the current servers: %v", o.configFile, err)
+				continue
+			}
+			log.Infof("reloaded %v", o.configFile)
+		}
+	}
+}
+
+// load reads the servers and sets the dialer of d. The config file is
+// returned if there is one.
+func (o *options) load(d *swapDialer, log *logrus.Logger) (*config.File, error) {
+	var (
+		cfgs []*config.Config
+		file *config.File
+		err  error
+	)
+	switch {
+	case o.configFile != "":
+		if file, err = config.LoadJSONFile(o.configFile); err != nil {
+			return nil, err
+		}
+		if cfgs, err = file.Configs(); err != nil {
+			return nil, err
+		}
+	case o.links != "":
+		return nil, o.loadLinks(d, log)
+	default:
+		cfgs = []*config.Config{{
+			Server:        o.server,
+			Port:          o.port,
+			Method:        o.method,
+			Password:      o.password,
+			Obfs:          o.obfs,
+			ObfsParam:     o.obfsParam,
+			Protocol:      o.protocol,
+			ProtocolParam: o.protocolParam,
+		}}
+	}
+
+	server, err := o.serverDialer()
+	if err != nil {
+		return nil, err
+	}
+	dialers := make([]client.Dialer, 0, len(cfgs))
+	for _, c := range cfgs {
+		s, err := client.NewSSRFromConfig(c, server, log)
+		if err != nil {
+			closeAll(dialers)
+			return nil, fmt.Errorf("server %v: %w", c.Addr(), err)
+		}
+		dialers = append(dialers, s)
+		log.Infof("server %v, method %v, obfs %v, protocol %v", c.Addr(), s.EncryptMethod, s.Obfs, s.Protocol)
+	}
+	if err = o.setDialers(d, dialers, log); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// loadLinks sets the dialer of d to the servers of the links, starting the
+// plugins they need.
+func (o *options) loadLinks(d *swapDialer, log *logrus.Logger) error {
+	server, err := o.serverDialer()
+	if err != nil {
+		return err
+	}
+	var dialers []client.Dialer
+	for _, s := range strings.Split(o.links, ",") {
+		l, err := config.ParseLink(s)
+		if err != nil {
+			closeAll(dialers)
+			return err
+		}
+		c, native := l.NativeConfig()
+		if !native && server != proxy.Direct {
+			closeAll(dialers)
+			return fmt.Errorf("%v: plugin %v cannot run over -tls or -ws-path", l.Addr(), l.Plugin)
+		}
+		dl, err := client.NewDialer(l, server, log)
+		if err != nil {
+			closeAll(dialers)
+			return fmt.Errorf("server %v: %w", l.Addr(), err)
+		}
+		dialers = append(dialers, dl)
+		c.SetDefaults()
+		if native {
+			log.Infof("server %v, method %v, obfs %v, protocol %v", c.Addr(), c.Method, c.Obfs, c.Protocol)
+		} else {
+			log.Infof("server %v, method %v, plugin %v", c.Addr(), c.Method, l.Plugin)
+		}
+	}
+	return o.setDialers(d, dialers, log)
+}
+
+// setDialers sets the dialer of d to dialers, grouped if there are several,
+// and closes the previous ones.
+func (o *options) setDialers(d *swapDialer, dialers []client.Dialer, log *logrus.Logger) error {
+	if len(dialers) == 1 {
+		d.set(dialers[0], dialers)
+		return nil
+	}
+	strategy, err := client.ParseStrategy(o.strategy)
+	if err != nil {
+		closeAll(dialers)
+		return err
+	}
+	g, err := client.NewDialerGroup(dialers, strategy, log)
+	if err != nil {
+		closeAll(dialers)
+		return err
+	}
+	d.set(g, dialers)
+	return nil
+}
+
+func closeAll(dialers []client.Dialer) {
+	for _, d := range dialers {
+		d.Close()
+	}
+}
+
+// serverDialer returns the dialer of the server connections, with the TLS
+// and WebSocket transports of the flags.
+func (o *options) serverDialer() (proxy.Dialer, error) {
+	var d proxy.Dialer = proxy.Direct
+	if o.tls {
+		t := &transport.TLS{Dialer: d, ServerName: o.tlsSNI}
+		if o.tlsALPN != "" {
+			t.ALPN = strings.Split(o.tlsALPN, ",")
+		}
+		if o.tlsCA != "" {
+			pool, err := transport.LoadCertPool(o.tlsCA)
+			if err != nil {
+				return nil, err
+			}
+			t.RootCAs = pool
+		}
+		if o.tlsPins != "" {
+			for _, s := range strings.Split(o.tlsPins, ",") {
+				pin, err := transport.ParsePin(s)
+				if err != nil {
+					return nil, err
+				}
+				t.Pins = append(t.Pins, pin)
+			}
+		}
+		d = t
+	}
+	if o.wsPath != "" {
+		d = &transport.WebSocket{Dialer: d, Path: o.wsPath, Host: o.wsHost, Header: o.wsHeader.header}
+	}
+	return d, nil
+}
+
+// headerFlag collects the repeated -ws-header flags.
+type headerFlag struct {
+	header http.Header
+}
+
+func (f *headerFlag) String() string {
+	if f.header == nil {
+		return ""
+	}
+	return fmt.Sprint(f.header)
+}
+
+func (f *headerFlag) Set(s string) error {
+	if f.header == nil {
+		f.header = http.Header{}
+	}
+	return transport.ParseHeader(f.header, s)
+}
+
+// swapDialer forwards to a dialer that is replaced when the config is reloaded.
+type swapDialer struct {
+	mu      sync.RWMutex
+	dialer  proxy.Dialer
+	servers []client.Dialer
+}
+
+// set replaces the dialer, and closes the servers of the previous one.
+func (d *swapDialer) set(dialer proxy.Dialer, servers []client.Dialer) {
+	d.mu.Lock()
+	old := d.servers
+	d.dialer, d.servers = dialer, servers
+	d.mu.Unlock()
+	closeAll(old)
+}
+
+// close closes the servers, stopping their plugins.
+func (d *swapDialer) close() {
+	d.mu.Lock()
+	old := d.servers
+	d.servers = nil
+	d.mu.Unlock()
+	closeAll(old)
+}
+
+func (d *swapDialer) Dial(network, addr string) (net.Conn, error) {
+	d.mu.RLock()
+	dialer := d.dialer
+	d.mu.RUnlock()
+	if dialer == nil {
+		return nil, errors.New("no server")
+	}
+	return dialer.Dial(network, addr)
+}
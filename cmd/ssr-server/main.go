@@ -0,0 +1,226 @@
+// Command ssr-server serves SSR clients on one or several ports.
+//
+// The ports come from a shadowsocksr JSON config file (-c), either a single
+// server_port or port_password entries, which may set their own method, obfs
+// and protocol, or from flags. Only the plain obfs and the origin protocol
+// are supported on the server side; multi-user by UID, which needs one of the
+// auth_* protocols, is not supported.
+//
+// With -tls-cert and -tls-key, the ports serve TLS, inside which the SSR
+// connections run. With -ws-path, they serve WebSocket requests of that path
+// instead of raw TCP, so that the server can be put behind an HTTP reverse
+// proxy or CDN. Both may be combined.
+//
+// On SIGINT or SIGTERM the server stops accepting connections and waits up to
+// -grace for the open ones to finish.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/server"
+	"github.com/v2rayA/shadowsocksR/transport"
+	"golang.org/x/net/proxy"
+)
+
+type options struct {
+	configFile string
+	bind       string
+
+	port          int
+	method        string
+	password      string
+	obfs          string
+	obfsParam     string
+	protocol      string
+	protocolParam string
+	timeout       time.Duration
+	wsPath        string
+	tlsCert       string
+	tlsKey        string
+	tlsALPN       string
+
+	report   time.Duration
+	grace    time.Duration
+	logLevel string
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.configFile, "c", "", "shadowsocksr JSON config file")
+	flag.StringVar(&o.bind, "b", "", "bind address, server of the config file or 0.0.0.0 if empty")
+	flag.IntVar(&o.port, "p", 8388, "server port")
+	flag.StringVar(&o.method, "m", config.DefaultMethod, "encrypt method")
+	flag.StringVar(&o.password, "k", "", "password")
+	flag.StringVar(&o.obfs, "o", config.DefaultObfs, "obfs")
+	flag.StringVar(&o.obfsParam, "g", "", "obfs param")
+	flag.StringVar(&o.protocol, "O", config.DefaultProtocol, "protocol")
+	flag.StringVar(&o.protocolParam, "G", "", "protocol param")
+	flag.DurationVar(&o.timeout, "t", 0, "idle timeout of connections, none if zero")
+	flag.StringVar(&o.wsPath, "ws-path", "", "serve WebSocket requests of this path instead of raw TCP if not empty")
+	flag.StringVar(&o.tlsCert, "tls-cert", "", "PEM certificate file, serve TLS if not empty")
+	flag.StringVar(&o.tlsKey, "tls-key", "", "PEM key file of the TLS certificate")
+	flag.StringVar(&o.tlsALPN, "tls-alpn", "", "comma separated ALPN protocols of the TLS server")
+	flag.DurationVar(&o.report, "report", time.Minute, "interval of traffic reports, none if zero")
+	flag.DurationVar(&o.grace, "grace", 30*time.Second, "time to wait for open connections on shutdown")
+	flag.StringVar(&o.logLevel, "log-level", "info", "log level: panic, fatal, error, warn, info, debug or trace")
+	flag.Parse()
+
+	log := logrus.New()
+	level, err := logrus.ParseLevel(o.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.SetLevel(level)
+
+	if (o.tlsCert == "") != (o.tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+	cfgs, err := o.configs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	servers := make([]*server.Server, 0, len(cfgs))
+	for _, c := range cfgs {
+		s, err := server.New(c, proxy.Direct, log)
+		if err != nil {
+			log.Fatalf("port %v: %v", c.Port, err)
+		}
+		servers = append(servers, s)
+	}
+
+	errCh := make(chan error, len(cfgs))
+	for i, s := range servers {
+		c, s := cfgs[i], s
+		go func() {
+			log.Infof("listening on %v, method %v, obfs %v, protocol %v", c.Addr(), c.Method, c.Obfs, c.Protocol)
+			if err := o.serve(s, c); err != server.ErrServerClosed {
+				errCh <- fmt.Errorf("port %v: %w", c.Port, err)
+			}
+		}()
+	}
+
+	var tick <-chan time.Time
+	if o.report > 0 {
+		t := time.NewTicker(o.report)
+		defer t.Stop()
+		tick = t.C
+	}
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	for {
+		select {
+		case err := <-errCh:
+			log.Fatal(err)
+		case <-tick:
+			for _, s := range servers {
+				st := s.Stats()
+				log.Infof("port %v: %d open, %d total connections, %d bytes in, %d bytes out",
+					s.Port(), st.Conns, st.TotalConns, st.BytesIn, st.BytesOut)
+			}
+		case sig := <-sigCh:
+			log.Infof("received %v, draining connections for up to %v", sig, o.grace)
+			shutdown(servers, o.grace, log)
+			return
+		}
+	}
+}
+
+// configs returns the configs of the ports to serve.
+func (o *options) configs() ([]*config.Config, error) {
+	var cfgs []*config.Config
+	if o.configFile != "" {
+		file, err := config.LoadJSONFile(o.configFile)
+		if err != nil {
+			return nil, err
+		}
+		if cfgs, err = file.Configs(); err != nil {
+			return nil, err
+		}
+	} else {
+		cfgs = []*config.Config{{
+			Port:          o.port,
+			Method:        o.method,
+			Password:      o.password,
+			Obfs:          o.obfs,
+			ObfsParam:     o.obfsParam,
+			Protocol:      o.protocol,
+			ProtocolParam: o.protocolParam,
+			Timeout:       o.timeout,
+		}}
+	}
+	for _, c := range cfgs {
+		if o.bind != "" {
+			c.Server = o.bind
+		}
+		if c.Server == "" {
+			c.Server = net.IPv4zero.String()
+		}
+		if o.timeout > 0 {
+			c.Timeout = o.timeout
+		}
+	}
+	return cfgs, nil
+}
+
+// serve serves the port of c with s, over TLS if -tls-cert is set and over
+// WebSocket if -ws-path is set.
+func (o *options) serve(s *server.Server, c *config.Config) error {
+	if o.tlsCert == "" && o.wsPath == "" {
+		return s.ListenAndServe()
+	}
+	var (
+		l   net.Listener
+		err error
+	)
+	if o.tlsCert != "" {
+		l, err = transport.ListenTLS(c.Addr(), o.tlsCert, o.tlsKey, splitList(o.tlsALPN))
+	} else {
+		l, err = net.Listen("tcp", c.Addr())
+	}
+	if err != nil {
+		return err
+	}
+	if o.wsPath != "" {
+		l = transport.ServeWebSocket(l, o.wsPath)
+	}
+	return s.Serve(l)
+}
+
+// splitList splits a comma separated list, nil if empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// shutdown shuts the servers down concurrently, closing the connections
+// still open after grace.
+func shutdown(servers []*server.Server, grace time.Duration, log *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	done := make(chan struct{})
+	for _, s := range servers {
+		s := s
+		go func() {
+			if err := s.Shutdown(ctx); err != nil {
+				log.Warnf("port %v: %v, closed the connections still open", s.Port(), err)
+			}
+			done <- struct{}{}
+		}()
+	}
+	for range servers {
+		<-done
+	}
+}
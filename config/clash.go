@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"gopkg.in/yaml.v3"
+)
+
+var clashNames = newNames("clash",
+	[]string{"aes-128-cfb", "aes-192-cfb", "aes-256-cfb", "aes-128-ctr", "aes-192-ctr", "aes-256-ctr",
+		"rc4-md5", "chacha20", "chacha20-ietf", "xchacha20", "none"},
+	[]string{"plain", "http_simple", "http_post", "random_head", "tls1.2_ticket_auth", "tls1.2_ticket_fastauth"},
+	[]string{"origin", "auth_sha1_v4", "auth_aes128_md5", "auth_aes128_sha1", "auth_chain_a", "auth_chain_b"},
+)
+
+// ClashProxy is a Clash proxy entry of type ssr.
+type ClashProxy struct {
+	Name          string `yaml:"name"`
+	Type          string `yaml:"type"`
+	Server        string `yaml:"server"`
+	Port          int    `yaml:"port"`
+	Cipher        string `yaml:"cipher"`
+	Password      string `yaml:"password"`
+	Obfs          string `yaml:"obfs"`
+	ObfsParam     string `yaml:"obfs-param,omitempty"`
+	Protocol      string `yaml:"protocol"`
+	ProtocolParam string `yaml:"protocol-param,omitempty"`
+	UDP           bool   `yaml:"udp,omitempty"`
+}
+
+// ParseClash returns the ssr proxies of b, which is either a Clash config
+// with a proxies list, a list of proxies or a single proxy. Proxies of
+// other types are skipped.
+func ParseClash(b []byte) ([]*ClashProxy, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return nil, &ssr.ConfigError{Err: fmt.Errorf("parse yaml: %w", err)}
+	}
+	if len(node.Content) == 0 {
+		return nil, nil
+	}
+	root := node.Content[0]
+
+	var proxies []*ClashProxy
+	var err error
+	switch root.Kind {
+	case yaml.SequenceNode:
+		err = root.Decode(&proxies)
+	case yaml.MappingNode:
+		var config struct {
+			Proxies []*ClashProxy `yaml:"proxies"`
+		}
+		if err = root.Decode(&config); err == nil && config.Proxies == nil {
+			var p ClashProxy
+			err = root.Decode(&p)
+			config.Proxies = []*ClashProxy{&p}
+		}
+		proxies = config.Proxies
+	default:
+		err = fmt.Errorf("unexpected yaml node")
+	}
+	if err != nil {
+		return nil, &ssr.ConfigError{Err: fmt.Errorf("parse yaml: %w", err)}
+	}
+
+	ssrProxies := proxies[:0]
+	for _, p := range proxies {
+		if p != nil && p.Type == "ssr" {
+			ssrProxies = append(ssrProxies, p)
+		}
+	}
+	return ssrProxies, nil
+}
+
+// MarshalClash returns proxies as the proxies list of a Clash config.
+func MarshalClash(proxies []*ClashProxy) ([]byte, error) {
+	return yaml.Marshal(struct {
+		Proxies []*ClashProxy `yaml:"proxies"`
+	}{proxies})
+}
+
+// FromClash converts p to a Config.
+func FromClash(p *ClashProxy) (*Config, error) {
+	if p.Type != "ssr" {
+		return nil, &ssr.ConfigError{Field: "type", Err: fmt.Errorf("not an ssr proxy: %s", p.Type)}
+	}
+	c := &Config{
+		Server:        p.Server,
+		Port:          p.Port,
+		Method:        p.Cipher,
+		Password:      p.Password,
+		Obfs:          p.Obfs,
+		ObfsParam:     p.ObfsParam,
+		Protocol:      p.Protocol,
+		ProtocolParam: p.ProtocolParam,
+	}
+	// clash calls the none method dummy
+	if c.Method == "dummy" {
+		c.Method = "none"
+	}
+	c.SetDefaults()
+	if err := clashNames.check(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ToClash converts c to a Clash proxy entry named name.
+func ToClash(c *Config, name string) (*ClashProxy, error) {
+	cfg := *c
+	cfg.SetDefaults()
+	if err := clashNames.check(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &ClashProxy{
+		Name:          name,
+		Type:          "ssr",
+		Server:        cfg.Server,
+		Port:          cfg.Port,
+		Cipher:        cfg.Method,
+		Password:      cfg.Password,
+		Obfs:          cfg.Obfs,
+		ObfsParam:     portableObfsParam(&cfg),
+		Protocol:      cfg.Protocol,
+		ProtocolParam: cfg.ProtocolParam,
+	}
+	if p.Cipher == "none" {
+		p.Cipher = "dummy"
+	}
+	return p, nil
+}
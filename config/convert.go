@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/v2rayA/shadowsocksR/obfs"
+	"github.com/v2rayA/shadowsocksR/protocol"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"github.com/v2rayA/shadowsocksR/streamCipher"
+)
+
+// names lists the methods, obfs and protocols known by another tool.
+type names struct {
+	tool      string
+	methods   map[string]bool
+	obfs      map[string]bool
+	protocols map[string]bool
+}
+
+func newNames(tool string, methods, obfs, protocols []string) *names {
+	set := func(l []string) map[string]bool {
+		m := make(map[string]bool, len(l))
+		for _, s := range l {
+			m[s] = true
+		}
+		return m
+	}
+	return &names{tool: tool, methods: set(methods), obfs: set(obfs), protocols: set(protocols)}
+}
+
+// check reports, in an Errors, the method, obfs and protocol of c that this
+// library or n.tool does not support. c must have its defaults set.
+func (n *names) check(c *Config) error {
+	var errs Errors
+	notSupported := func(kind, name string) {
+		errs = append(errs, &ssr.ConfigError{Field: kind, Err: fmt.Errorf("%s %s is not supported by %s", kind, name, n.tool)})
+	}
+	if streamCipher.CheckCipherMethod(c.Method) != nil {
+		errs = append(errs, &ssr.UnsupportedError{Kind: "method", Name: c.Method})
+	} else if !n.methods[c.Method] {
+		notSupported("method", c.Method)
+	}
+	if obfs.NewObfs(c.Obfs) == nil {
+		errs = append(errs, &ssr.UnsupportedError{Kind: "obfs", Name: c.Obfs})
+	} else if !n.obfs[c.Obfs] {
+		notSupported("obfs", c.Obfs)
+	}
+	if protocol.NewProtocol(c.Protocol) == nil {
+		errs = append(errs, &ssr.UnsupportedError{Kind: "protocol", Name: c.Protocol})
+	} else if !n.protocols[c.Protocol] {
+		notSupported("protocol", c.Protocol)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// isTLSTicketAuth reports whether name is tls1.2_ticket_auth or
+// tls1.2_ticket_fastauth, whose obfs param may select a ClientHello profile.
+func isTLSTicketAuth(name string) bool {
+	return strings.HasPrefix(name, "tls1.2_ticket_")
+}
+
+// portableObfsParam returns the obfs param of c for other tools, without the
+// ClientHello profile of tls1.2_ticket_auth that only this library knows.
+func portableObfsParam(c *Config) string {
+	if !isTLSTicketAuth(c.Obfs) {
+		return c.ObfsParam
+	}
+	hosts, _ := obfs.SplitTLSProfile(c.ObfsParam)
+	return hosts
+}
+
+func contains(l []string, s string) bool {
+	for _, x := range l {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
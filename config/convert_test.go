@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+func TestClash(t *testing.T) {
+	proxies, err := ParseClash([]byte(`
+proxies:
+  - name: direct
+    type: socks5
+    server: 127.0.0.1
+    port: 1080
+  - name: node
+    type: ssr
+    server: example.com
+    port: 443
+    cipher: dummy
+    password: pass
+    obfs: tls1.2_ticket_auth
+    obfs-param: cloudflare.com
+    protocol: auth_chain_a
+    protocol-param: "64:key"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 1 || proxies[0].Name != "node" {
+		t.Fatalf("unexpected proxies: %+v", proxies)
+	}
+	c, err := FromClash(proxies[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Method != "none" || c.ObfsParam != "cloudflare.com" || c.ProtocolParam != "64:key" {
+		t.Fatalf("unexpected config: %+v", c)
+	}
+	p, err := ToClash(c, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *p != *proxies[0] {
+		t.Fatalf("round trip: got %+v, want %+v", p, proxies[0])
+	}
+
+	// the ClientHello profile is only known by the library
+	c.ObfsParam = "cloudflare.com#chrome"
+	if p, err = ToClash(c, "node"); err != nil || p.ObfsParam != "cloudflare.com" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	c.ObfsParam = "cloudflare.com#opera"
+	if _, err = ToClash(c, "node"); !errors.Is(err, ssr.ErrUnsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c.ObfsParam = "cloudflare.com"
+
+	// verify_sha1 is supported by the library but not by clash
+	c.Protocol = "verify_sha1"
+	if _, err = ToClash(c, "node"); !errors.Is(err, ssr.ErrConfig) || errors.Is(err, ssr.ErrUnsupported) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSingBox(t *testing.T) {
+	outbounds, err := ParseSingBox([]byte(`{
+  "outbounds": [
+    {"type": "direct", "tag": "direct"},
+    {"type": "shadowsocksr", "tag": "node", "server": "example.com", "server_port": 443,
+     "method": "aes-256-cfb", "password": "pass", "obfs": "http_simple", "protocol": "unknown"}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outbounds) != 1 || outbounds[0].Tag != "node" {
+		t.Fatalf("unexpected outbounds: %+v", outbounds)
+	}
+	var ue *ssr.UnsupportedError
+	if _, err = FromSingBox(outbounds[0]); !errors.As(err, &ue) || ue.Name != "unknown" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	outbounds[0].Protocol = "auth_aes128_md5"
+	c, err := FromSingBox(outbounds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := ToSingBox(c, "node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *o != *outbounds[0] {
+		t.Fatalf("round trip: got %+v, want %+v", o, outbounds[0])
+	}
+}
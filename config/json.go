@@ -0,0 +1,249 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+// File is a JSON configuration file of the Python shadowsocksr and of
+// shadowsocksr-libev. Keys it does not know are kept in Extra, so that a file
+// is written back out without losing them.
+type File struct {
+	Server        string `json:"server"`
+	ServerPort    int    `json:"server_port,omitempty"`
+	LocalAddress  string `json:"local_address,omitempty"`
+	LocalPort     int    `json:"local_port,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Method        string `json:"method"`
+	Protocol      string `json:"protocol,omitempty"`
+	ProtocolParam string `json:"protocol_param,omitempty"`
+	Obfs          string `json:"obfs,omitempty"`
+	ObfsParam     string `json:"obfs_param,omitempty"`
+	// PortPassword serves several ports, keyed by port number, instead of
+	// ServerPort and Password.
+	PortPassword map[string]PortPassword `json:"port_password,omitempty"`
+	// Timeout is the idle timeout in seconds.
+	Timeout int `json:"timeout,omitempty"`
+
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// PortPassword is an entry of port_password: either a password, or an object
+// overriding the password, method, protocol and obfs of the file for a port.
+type PortPassword struct {
+	Password      string `json:"password"`
+	Method        string `json:"method,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+	ProtocolParam string `json:"protocol_param,omitempty"`
+	Obfs          string `json:"obfs,omitempty"`
+	ObfsParam     string `json:"obfs_param,omitempty"`
+}
+
+// UnmarshalJSON accepts a password string or an object.
+func (p *PortPassword) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		*p = PortPassword{}
+		return json.Unmarshal(b, &p.Password)
+	}
+	type plain PortPassword
+	return json.Unmarshal(b, (*plain)(p))
+}
+
+// MarshalJSON writes a password string if nothing else is overridden.
+func (p PortPassword) MarshalJSON() ([]byte, error) {
+	if p == (PortPassword{Password: p.Password}) {
+		return json.Marshal(p.Password)
+	}
+	type plain PortPassword
+	return json.Marshal(plain(p))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (f *File) UnmarshalJSON(b []byte) error {
+	type plain File
+	if err := json.Unmarshal(b, (*plain)(f)); err != nil {
+		return err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return err
+	}
+	for _, k := range fileKeys() {
+		delete(all, k)
+	}
+	f.Extra = nil
+	if len(all) > 0 {
+		f.Extra = all
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (f File) MarshalJSON() ([]byte, error) {
+	type plain File
+	b, err := json.Marshal(plain(f))
+	if err != nil || len(f.Extra) == 0 {
+		return b, err
+	}
+	var all map[string]json.RawMessage
+	if err = json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range f.Extra {
+		if _, ok := all[k]; !ok {
+			all[k] = v
+		}
+	}
+	return json.Marshal(all)
+}
+
+// fileKeys returns the JSON keys of the fields of File.
+func fileKeys() []string {
+	return []string{"server", "server_port", "local_address", "local_port", "password", "method",
+		"protocol", "protocol_param", "obfs", "obfs_param", "port_password", "timeout"}
+}
+
+// LoadJSON reads a JSON configuration file from r.
+func LoadJSON(r io.Reader) (*File, error) {
+	f := &File{}
+	if err := json.NewDecoder(r).Decode(f); err != nil {
+		return nil, &ssr.ConfigError{Err: fmt.Errorf("parse json: %w", err)}
+	}
+	return f, nil
+}
+
+// LoadJSONFile reads the JSON configuration file at path.
+func LoadJSONFile(path string) (*File, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return LoadJSON(fd)
+}
+
+// WriteJSON writes f to w, indented as the files shipped with shadowsocksr.
+func (f *File) WriteJSON(w io.Writer) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = json.Indent(&buf, b, "", "    "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// SaveJSONFile writes f to the file at path.
+func (f *File) SaveJSONFile(path string) error {
+	var buf bytes.Buffer
+	if err := f.WriteJSON(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, buf.Bytes(), 0600)
+}
+
+// Configs returns the configuration of every port of f, ordered by port.
+func (f *File) Configs() ([]*Config, error) {
+	base := Config{
+		Server:        f.Server,
+		Port:          f.ServerPort,
+		Method:        f.Method,
+		Password:      f.Password,
+		Obfs:          f.Obfs,
+		ObfsParam:     f.ObfsParam,
+		Protocol:      f.Protocol,
+		ProtocolParam: f.ProtocolParam,
+		Timeout:       time.Duration(f.Timeout) * time.Second,
+	}
+	if len(f.PortPassword) == 0 {
+		c := base
+		return []*Config{&c}, nil
+	}
+
+	cfgs := make([]*Config, 0, len(f.PortPassword))
+	for port, pp := range f.PortPassword {
+		c := base
+		var err error
+		if c.Port, err = strconv.Atoi(port); err != nil {
+			return nil, &ssr.ConfigError{Field: "port_password", Err: err}
+		}
+		c.Password = pp.Password
+		if pp.Method != "" {
+			c.Method = pp.Method
+		}
+		if pp.Protocol != "" || pp.ProtocolParam != "" {
+			c.ProtocolParam = pp.ProtocolParam
+		}
+		if pp.Protocol != "" {
+			c.Protocol = pp.Protocol
+		}
+		if pp.Obfs != "" || pp.ObfsParam != "" {
+			c.ObfsParam = pp.ObfsParam
+		}
+		if pp.Obfs != "" {
+			c.Obfs = pp.Obfs
+		}
+		cfgs = append(cfgs, &c)
+	}
+	sort.Slice(cfgs, func(i, j int) bool { return cfgs[i].Port < cfgs[j].Port })
+	return cfgs, nil
+}
+
+// NewFile returns the JSON configuration file of cfgs, which must share their
+// server. Several configs are written as port_password entries, as objects
+// for the ports whose method, protocol or obfs differ from the first config.
+func NewFile(cfgs ...*Config) (*File, error) {
+	if len(cfgs) == 0 {
+		return nil, &ssr.ConfigError{Err: fmt.Errorf("no config")}
+	}
+	first := cfgs[0]
+	f := &File{
+		Server:        first.Server,
+		Method:        first.Method,
+		Protocol:      first.Protocol,
+		ProtocolParam: first.ProtocolParam,
+		Obfs:          first.Obfs,
+		ObfsParam:     first.ObfsParam,
+		Timeout:       int(first.Timeout / time.Second),
+	}
+	if len(cfgs) == 1 {
+		f.ServerPort, f.Password = first.Port, first.Password
+		return f, nil
+	}
+
+	f.PortPassword = make(map[string]PortPassword, len(cfgs))
+	for _, c := range cfgs {
+		if c.Server != first.Server {
+			return nil, &ssr.ConfigError{Field: "server", Err: fmt.Errorf("configs of different servers: %s and %s", first.Server, c.Server)}
+		}
+		port := strconv.Itoa(c.Port)
+		if _, ok := f.PortPassword[port]; ok {
+			return nil, &ssr.ConfigError{Field: "port", Err: fmt.Errorf("duplicate port: %s", port)}
+		}
+		pp := PortPassword{Password: c.Password}
+		if c.Method != first.Method {
+			pp.Method = c.Method
+		}
+		if c.Protocol != first.Protocol || c.ProtocolParam != first.ProtocolParam {
+			pp.Protocol, pp.ProtocolParam = c.Protocol, c.ProtocolParam
+		}
+		if c.Obfs != first.Obfs || c.ObfsParam != first.ObfsParam {
+			pp.Obfs, pp.ObfsParam = c.Obfs, c.ObfsParam
+		}
+		f.PortPassword[port] = pp
+	}
+	return f, nil
+}
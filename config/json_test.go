@@ -0,0 +1,81 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const multiPortJSON = `{
+    "server": "0.0.0.0",
+    "server_ipv6": "::",
+    "local_port": 1080,
+    "method": "aes-128-ctr",
+    "protocol": "auth_aes128_md5_compatible",
+    "obfs": "tls1.2_ticket_auth_compatible",
+    "port_password": {
+        "8389": {"password": "second", "protocol": "auth_chain_a", "protocol_param": "64"},
+        "8388": "first"
+    },
+    "timeout": 120
+}`
+
+func TestJSONPortPassword(t *testing.T) {
+	f, err := LoadJSON(strings.NewReader(multiPortJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfgs, err := f.Configs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfgs) != 2 {
+		t.Fatalf("expected 2 configs, got %d", len(cfgs))
+	}
+	if c := cfgs[0]; c.Port != 8388 || c.Password != "first" || c.Protocol != "auth_aes128_md5_compatible" || c.Obfs != "tls1.2_ticket_auth_compatible" {
+		t.Fatalf("unexpected first config: %+v", c)
+	}
+	if c := cfgs[1]; c.Port != 8389 || c.Password != "second" || c.Protocol != "auth_chain_a" || c.ProtocolParam != "64" {
+		t.Fatalf("unexpected second config: %+v", c)
+	}
+	for _, c := range cfgs {
+		if err = c.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// unknown keys and the string form of port_password survive a round trip
+	var buf bytes.Buffer
+	if err = f.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	if err = json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["server_ipv6"] != "::" {
+		t.Fatalf("server_ipv6 lost: %s", buf.String())
+	}
+	if pp := out["port_password"].(map[string]interface{}); pp["8388"] != "first" {
+		t.Fatalf("unexpected port_password: %s", buf.String())
+	}
+
+	// configs are written back to an equivalent file
+	f2, err := NewFile(cfgs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f2.Protocol != f.Protocol || f2.Obfs != f.Obfs {
+		t.Fatalf("round trip: got protocol %q and obfs %q", f2.Protocol, f2.Obfs)
+	}
+	cfgs2, err := f2.Configs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range cfgs {
+		if *cfgs[i] != *cfgs2[i] {
+			t.Fatalf("round trip: got %+v, want %+v", cfgs2[i], cfgs[i])
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+// Link is a server shared as a link, with its display name and group.
+type Link struct {
+	Config
+	Remarks string
+	Group   string
+	// Plugin is the SIP003 plugin of an ss:// link, its name followed by
+	// semicolon separated options.
+	Plugin string
+}
+
+// ParseLink parses an ssr:// or ss:// link.
+func ParseLink(s string) (*Link, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "ssr://"):
+		return ParseSSRLink(s)
+	case strings.HasPrefix(s, "ss://"):
+		return ParseSSLink(s)
+	}
+	return nil, &ssr.ConfigError{Field: "link", Err: fmt.Errorf("not an ssr:// or ss:// link")}
+}
+
+// ParseSubscription parses the links of a subscription body, one per line,
+// either as is or base64 encoded as a whole. Lines that are not valid links
+// are reported in an Errors, along with the links of the other lines.
+func ParseSubscription(b []byte) ([]*Link, error) {
+	body := strings.TrimSpace(string(b))
+	if !strings.Contains(body, "://") {
+		decoded, err := DecodeBase64(strings.Join(strings.Fields(body), ""))
+		if err != nil {
+			return nil, &ssr.ConfigError{Field: "subscription", Err: err}
+		}
+		body = decoded
+	}
+	var (
+		links []*Link
+		errs  Errors
+	)
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l, err := ParseLink(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		links = append(links, l)
+	}
+	if len(errs) > 0 {
+		return links, errs
+	}
+	return links, nil
+}
+
+// ParseSSRLink parses an ssr:// link, which is the URL-safe base64 of
+// host:port:protocol:method:obfs:base64(password)/?obfsparam=...&protoparam=...
+// with base64 query values.
+func ParseSSRLink(s string) (*Link, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "ssr://") {
+		return nil, &ssr.ConfigError{Field: "link", Err: fmt.Errorf("not an ssr:// link")}
+	}
+	decoded, err := DecodeBase64(s[len("ssr://"):])
+	if err != nil {
+		return nil, &ssr.ConfigError{Field: "link", Err: err}
+	}
+	main, rawQuery := decoded, ""
+	if i := strings.Index(decoded, "/?"); i >= 0 {
+		main, rawQuery = decoded[:i], decoded[i+2:]
+	} else if i = strings.Index(decoded, "?"); i >= 0 {
+		main, rawQuery = decoded[:i], decoded[i+1:]
+	}
+
+	// the host may be an IPv6 address, so split from the end
+	parts := strings.Split(main, ":")
+	if len(parts) < 6 {
+		return nil, &ssr.ConfigError{Field: "link", Err: fmt.Errorf("malformed ssr link: %s", main)}
+	}
+	n := len(parts)
+	host := strings.Trim(strings.Join(parts[:n-5], ":"), "[]")
+	port, err := strconv.Atoi(parts[n-5])
+	if err != nil {
+		return nil, &ssr.ConfigError{Field: "port", Err: err}
+	}
+	password, err := DecodeBase64(parts[n-1])
+	if err != nil {
+		return nil, &ssr.ConfigError{Field: "password", Err: err}
+	}
+	l := &Link{Config: Config{
+		Server:   host,
+		Port:     port,
+		Protocol: parts[n-4],
+		Method:   parts[n-3],
+		Obfs:     parts[n-2],
+		Password: password,
+	}}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, &ssr.ConfigError{Field: "link", Err: err}
+	}
+	for key, dst := range map[string]*string{
+		"obfsparam":  &l.ObfsParam,
+		"protoparam": &l.ProtocolParam,
+		"remarks":    &l.Remarks,
+		"group":      &l.Group,
+	} {
+		if v := query.Get(key); v != "" {
+			if *dst, err = DecodeBase64(v); err != nil {
+				return nil, &ssr.ConfigError{Field: key, Err: err}
+			}
+		}
+	}
+	return l, nil
+}
+
+// ParseSSLink parses a Shadowsocks link, either SIP002
+// ss://base64(method:password)@host:port/?plugin=...#tag or the legacy
+// ss://base64(method:password@host:port)#tag. The obfs is plain and the
+// protocol origin.
+func ParseSSLink(s string) (*Link, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "ss://") {
+		return nil, &ssr.ConfigError{Field: "link", Err: fmt.Errorf("not an ss:// link")}
+	}
+	s = s[len("ss://"):]
+	l := &Link{Config: Config{Obfs: DefaultObfs, Protocol: DefaultProtocol}}
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		tag, err := url.PathUnescape(s[i+1:])
+		if err != nil {
+			return nil, &ssr.ConfigError{Field: "tag", Err: err}
+		}
+		s, l.Remarks = s[:i], tag
+	}
+
+	var userinfo, hostport string
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		u, err := url.Parse("ss://" + s)
+		if err != nil {
+			return nil, &ssr.ConfigError{Field: "link", Err: err}
+		}
+		if password, ok := u.User.Password(); ok {
+			// method:password percent-encoded, as allowed by SIP002
+			userinfo = u.User.Username() + ":" + password
+		} else if userinfo, err = DecodeBase64(u.User.Username()); err != nil {
+			return nil, &ssr.ConfigError{Field: "userinfo", Err: err}
+		}
+		hostport = u.Host
+		l.Plugin = u.Query().Get("plugin")
+	} else {
+		decoded, err := DecodeBase64(strings.TrimSuffix(s, "/"))
+		if err != nil {
+			return nil, &ssr.ConfigError{Field: "link", Err: err}
+		}
+		i := strings.LastIndexByte(decoded, '@')
+		if i < 0 {
+			return nil, &ssr.ConfigError{Field: "link", Err: fmt.Errorf("malformed ss link: %s", decoded)}
+		}
+		userinfo, hostport = decoded[:i], decoded[i+1:]
+	}
+
+	i := strings.IndexByte(userinfo, ':')
+	if i < 0 {
+		return nil, &ssr.ConfigError{Field: "userinfo", Err: fmt.Errorf("missing password")}
+	}
+	l.Method, l.Password = strings.ToLower(userinfo[:i]), userinfo[i+1:]
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, &ssr.ConfigError{Field: "server", Err: err}
+	}
+	if l.Port, err = strconv.Atoi(port); err != nil {
+		return nil, &ssr.ConfigError{Field: "port", Err: err}
+	}
+	l.Server = host
+	return l, nil
+}
+
+// SSRLink returns l as an ssr:// link.
+func (l *Link) SSRLink() string {
+	c := l.Config
+	c.SetDefaults()
+	host := c.Server
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	main := strings.Join([]string{host, strconv.Itoa(c.Port), c.Protocol, c.Method, c.Obfs, encodeBase64(c.Password)}, ":")
+	var query []string
+	for _, kv := range [][2]string{
+		{"obfsparam", c.ObfsParam},
+		{"protoparam", c.ProtocolParam},
+		{"remarks", l.Remarks},
+		{"group", l.Group},
+	} {
+		if kv[1] != "" {
+			query = append(query, kv[0]+"="+encodeBase64(kv[1]))
+		}
+	}
+	return "ssr://" + encodeBase64(main+"/?"+strings.Join(query, "&"))
+}
+
+// SSLink returns l as a SIP002 ss:// link. Its protocol must be origin, and
+// its obfs plain or one of simple-obfs, which is shared as the obfs-local
+// plugin.
+func (l *Link) SSLink() (string, error) {
+	c := l.Config
+	c.SetDefaults()
+	plugin := l.Plugin
+	if mode := strings.TrimPrefix(c.Obfs, "simple_obfs_"); mode != c.Obfs && plugin == "" && c.Protocol == "origin" {
+		plugin = "obfs-local;obfs=" + mode
+		if strings.Contains(c.ObfsParam, "=") {
+			plugin += ";" + c.ObfsParam
+		} else if c.ObfsParam != "" {
+			plugin += ";obfs-host=" + c.ObfsParam
+		}
+	} else if c.Obfs != "plain" || c.Protocol != "origin" {
+		return "", &ssr.ConfigError{Field: "link", Err: fmt.Errorf("obfs %s and protocol %s cannot be shared as an ss:// link", c.Obfs, c.Protocol)}
+	}
+	u := url.URL{
+		Scheme:   "ss",
+		User:     url.User(encodeBase64(c.Method + ":" + c.Password)),
+		Host:     net.JoinHostPort(c.Server, strconv.Itoa(c.Port)),
+		Fragment: l.Remarks,
+	}
+	if plugin != "" {
+		u.Path = "/"
+		u.RawQuery = url.Values{"plugin": {plugin}}.Encode()
+	}
+	return u.String(), nil
+}
+
+// NativeConfig returns the config of l, with its simple-obfs plugin, if any,
+// replaced by the simple_obfs_http or simple_obfs_tls obfs. ok is false if l
+// has a plugin that is not implemented natively.
+func (l *Link) NativeConfig() (c Config, ok bool) {
+	c = l.Config
+	if l.Plugin == "" {
+		return c, true
+	}
+	opts := strings.Split(l.Plugin, ";")
+	if name := strings.TrimSpace(opts[0]); name != "obfs-local" && name != "simple-obfs" {
+		return c, false
+	}
+	var mode string
+	var params []string
+	for _, opt := range opts[1:] {
+		if v := strings.TrimPrefix(opt, "obfs="); v != opt {
+			mode = v
+		} else if opt != "" {
+			params = append(params, opt)
+		}
+	}
+	if mode != "http" && mode != "tls" {
+		return c, false
+	}
+	c.Obfs, c.ObfsParam = "simple_obfs_"+mode, strings.Join(params, ";")
+	return c, true
+}
+
+// DecodeBase64 decodes s in any of the base64 variants found in links and
+// subscriptions: standard or URL-safe alphabet, with or without padding.
+func DecodeBase64(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "=")
+	s = strings.NewReplacer("-", "+", "_", "/").Replace(s)
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func encodeBase64(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSSRLink(t *testing.T) {
+	l := &Link{
+		Config: Config{Server: "2001:db8::1", Port: 8388, Method: "aes-256-cfb", Password: "pass",
+			Obfs: "tls1.2_ticket_auth", ObfsParam: "example.com", Protocol: "auth_chain_a", ProtocolParam: "64:key"},
+		Remarks: "node 1",
+		Group:   "group",
+	}
+	parsed, err := ParseSSRLink(l.SSRLink())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *parsed != *l {
+		t.Fatalf("round trip: got %+v, want %+v", parsed, l)
+	}
+
+	// padded standard base64, as found in some subscriptions
+	parsed, err = ParseSSRLink("ssr://MTI3LjAuMC4xOjEyMzQ6YXV0aF9hZXMxMjhfbWQ1OmFlcy0xMjgtY2ZiOnRsczEuMl90aWNrZXRfYXV0aDpZV0ZoWW1KaS8_b2Jmc3BhcmFtPVluQmhZV05wWm1kc0xtTnZiUQ==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Server != "127.0.0.1" || parsed.Port != 1234 || parsed.Password != "aaabbb" || parsed.ObfsParam != "bpaacifgl.com" {
+		t.Fatalf("unexpected link: %+v", parsed)
+	}
+}
+
+func TestSSLink(t *testing.T) {
+	for link, want := range map[string]Link{
+		// SIP002 with a base64 userinfo
+		"ss://YWVzLTI1Ni1jZmI6cGFzczpwYXJ0@192.168.100.1:8888/?plugin=obfs-local%3Bobfs%3Dhttp#Example%20node": {
+			Config:  Config{Server: "192.168.100.1", Port: 8888, Method: "aes-256-cfb", Password: "pass:part", Obfs: "plain", Protocol: "origin"},
+			Remarks: "Example node",
+			Plugin:  "obfs-local;obfs=http",
+		},
+		// SIP002 with a percent-encoded userinfo
+		"ss://chacha20-ietf:p%40ss@[2001:db8::1]:443": {
+			Config: Config{Server: "2001:db8::1", Port: 443, Method: "chacha20-ietf", Password: "p@ss", Obfs: "plain", Protocol: "origin"},
+		},
+		// legacy
+		"ss://cmM0LW1kNTpwQHNzQGV4YW1wbGUuY29tOjgzODg=#legacy": {
+			Config:  Config{Server: "example.com", Port: 8388, Method: "rc4-md5", Password: "p@ss", Obfs: "plain", Protocol: "origin"},
+			Remarks: "legacy",
+		},
+	} {
+		l, err := ParseSSLink(link)
+		if err != nil {
+			t.Errorf("%s: %v", link, err)
+			continue
+		}
+		if *l != want {
+			t.Errorf("%s: got %+v, want %+v", link, l, want)
+		}
+	}
+	if _, err := ParseSSLink("ss://bm9wYXNzd29yZEBleGFtcGxlLmNvbTo4Mzg4"); err == nil {
+		t.Error("expected an error for a link without password")
+	}
+}
+
+func TestParseSubscription(t *testing.T) {
+	ssr := (&Link{Config: Config{Server: "example.com", Port: 8388, Password: "pass"}}).SSRLink()
+	body := ssr + "\r\nss://cmM0LW1kNTpwQHNzQGV4YW1wbGUuY29tOjgzODg=\r\n\r\nvmess://abc\r\n"
+	links, err := ParseSubscription([]byte(encodeBase64(body)))
+	if len(links) != 2 || links[0].Obfs != "plain" || links[1].Password != "p@ss" {
+		t.Fatalf("unexpected links: %+v", links)
+	}
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "line 4:") {
+		t.Fatalf("expected an error for line 4, got %v", err)
+	}
+}
+
+func TestSSLinkRoundTrip(t *testing.T) {
+	for _, l := range []*Link{
+		{Config: Config{Server: "example.com", Port: 8388, Method: "aes-256-cfb", Password: "p@ss:word", Obfs: "plain", Protocol: "origin"}, Remarks: "node #1"},
+		{Config: Config{Server: "2001:db8::1", Port: 443, Method: "chacha20-ietf", Password: "pass", Obfs: "plain", Protocol: "origin"}, Plugin: "v2ray-plugin;tls;host=example.com"},
+	} {
+		s, err := l.SSLink()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSSLink(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if *parsed != *l {
+			t.Errorf("%s: got %+v, want %+v", s, parsed, l)
+		}
+	}
+	l := &Link{Config: Config{Server: "example.com", Port: 8388, Password: "pass", Obfs: "http_simple"}}
+	if _, err := l.SSLink(); err == nil {
+		t.Error("expected an error for an obfs link")
+	}
+}
+
+func TestNativeConfig(t *testing.T) {
+	l := &Link{Config: Config{Server: "example.com", Port: 8388, Method: "aes-256-cfb", Password: "pass",
+		Obfs: "simple_obfs_tls", ObfsParam: "cdn.example.com", Protocol: "origin"}}
+	s, err := l.SSLink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSSLink(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Plugin != "obfs-local;obfs=tls;obfs-host=cdn.example.com" {
+		t.Fatalf("unexpected plugin: %q", parsed.Plugin)
+	}
+	c, ok := parsed.NativeConfig()
+	if !ok || c.Obfs != "simple_obfs_tls" || c.ObfsParam != "obfs-host=cdn.example.com" {
+		t.Fatalf("unexpected native config: %+v, %v", c, ok)
+	}
+	if _, ok = (&Link{Plugin: "v2ray-plugin;tls"}).NativeConfig(); ok {
+		t.Fatal("v2ray-plugin is not native")
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+var singBoxNames = newNames("sing-box",
+	[]string{"aes-128-cfb", "aes-192-cfb", "aes-256-cfb", "aes-128-ctr", "aes-192-ctr", "aes-256-ctr",
+		"rc4-md5", "chacha20-ietf", "xchacha20", "none"},
+	[]string{"plain", "http_simple", "http_post", "random_head", "tls1.2_ticket_auth", "tls1.2_ticket_fastauth"},
+	[]string{"origin", "verify_sha1", "auth_sha1_v4", "auth_aes128_md5", "auth_aes128_sha1", "auth_chain_a", "auth_chain_b"},
+)
+
+// SingBoxOutbound is a sing-box outbound of type shadowsocksr.
+type SingBoxOutbound struct {
+	Type          string `json:"type"`
+	Tag           string `json:"tag,omitempty"`
+	Server        string `json:"server"`
+	ServerPort    int    `json:"server_port"`
+	Method        string `json:"method"`
+	Password      string `json:"password"`
+	Obfs          string `json:"obfs,omitempty"`
+	ObfsParam     string `json:"obfs_param,omitempty"`
+	Protocol      string `json:"protocol,omitempty"`
+	ProtocolParam string `json:"protocol_param,omitempty"`
+	// Network is "tcp" or "udp" to enable only one of them, both if empty.
+	Network string `json:"network,omitempty"`
+}
+
+// ParseSingBox returns the shadowsocksr outbounds of b, which is either a
+// sing-box config with an outbounds list, a list of outbounds or a single
+// outbound. Outbounds of other types are skipped.
+func ParseSingBox(b []byte) ([]*SingBoxOutbound, error) {
+	b = bytes.TrimSpace(b)
+	var outbounds []*SingBoxOutbound
+	var err error
+	if len(b) > 0 && b[0] == '[' {
+		err = json.Unmarshal(b, &outbounds)
+	} else {
+		var config struct {
+			Outbounds []*SingBoxOutbound `json:"outbounds"`
+		}
+		if err = json.Unmarshal(b, &config); err == nil && config.Outbounds == nil {
+			var o SingBoxOutbound
+			err = json.Unmarshal(b, &o)
+			config.Outbounds = []*SingBoxOutbound{&o}
+		}
+		outbounds = config.Outbounds
+	}
+	if err != nil {
+		return nil, &ssr.ConfigError{Err: fmt.Errorf("parse json: %w", err)}
+	}
+
+	ssrOutbounds := outbounds[:0]
+	for _, o := range outbounds {
+		if o != nil && o.Type == "shadowsocksr" {
+			ssrOutbounds = append(ssrOutbounds, o)
+		}
+	}
+	return ssrOutbounds, nil
+}
+
+// MarshalSingBox returns outbounds as the outbounds list of a sing-box config.
+func MarshalSingBox(outbounds []*SingBoxOutbound) ([]byte, error) {
+	return json.MarshalIndent(struct {
+		Outbounds []*SingBoxOutbound `json:"outbounds"`
+	}{outbounds}, "", "  ")
+}
+
+// FromSingBox converts o to a Config.
+func FromSingBox(o *SingBoxOutbound) (*Config, error) {
+	if o.Type != "shadowsocksr" {
+		return nil, &ssr.ConfigError{Field: "type", Err: fmt.Errorf("not a shadowsocksr outbound: %s", o.Type)}
+	}
+	c := &Config{
+		Server:        o.Server,
+		Port:          o.ServerPort,
+		Method:        o.Method,
+		Password:      o.Password,
+		Obfs:          o.Obfs,
+		ObfsParam:     o.ObfsParam,
+		Protocol:      o.Protocol,
+		ProtocolParam: o.ProtocolParam,
+	}
+	c.SetDefaults()
+	if err := singBoxNames.check(c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ToSingBox converts c to a sing-box outbound tagged tag.
+func ToSingBox(c *Config, tag string) (*SingBoxOutbound, error) {
+	cfg := *c
+	cfg.SetDefaults()
+	if err := singBoxNames.check(&cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &SingBoxOutbound{
+		Type:          "shadowsocksr",
+		Tag:           tag,
+		Server:        cfg.Server,
+		ServerPort:    cfg.Port,
+		Method:        cfg.Method,
+		Password:      cfg.Password,
+		Obfs:          cfg.Obfs,
+		ObfsParam:     portableObfsParam(&cfg),
+		Protocol:      cfg.Protocol,
+		ProtocolParam: cfg.ProtocolParam,
+	}, nil
+}
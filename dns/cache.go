@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultCacheSize is the maximum number of answers kept by a Resolver.
+const DefaultCacheSize = 4096
+
+type cacheItem struct {
+	msg    []byte
+	stored time.Time
+	expire time.Time
+}
+
+type cache struct {
+	mu    sync.Mutex
+	items map[string]*cacheItem
+	size  int
+}
+
+func newCache(size int) *cache {
+	return &cache{
+		items: make(map[string]*cacheItem),
+		size:  size,
+	}
+}
+
+func cacheKey(q dnsmessage.Question) string {
+	return strings.ToLower(q.Name.String()) + "/" + q.Type.String() + "/" + q.Class.String()
+}
+
+// get returns the cached answer for q with its id set to id and TTLs reduced
+// by the time it has been cached, or nil if there is none.
+func (c *cache) get(q dnsmessage.Question, id uint16) []byte {
+	key := cacheKey(q)
+	now := time.Now()
+	c.mu.Lock()
+	item, ok := c.items[key]
+	if ok && !now.Before(item.expire) {
+		delete(c.items, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var m dnsmessage.Message
+	if err := m.Unpack(item.msg); err != nil {
+		return nil
+	}
+	m.Header.ID = id
+	elapsed := uint32(now.Sub(item.stored) / time.Second)
+	forEachResource(&m, func(h *dnsmessage.ResourceHeader) {
+		if h.TTL > elapsed {
+			h.TTL -= elapsed
+		} else {
+			h.TTL = 0
+		}
+	})
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// put caches msg for q. Answers that carry no TTL or that indicate a failure
+// other than NXDOMAIN are not cached.
+func (c *cache) put(q dnsmessage.Question, msg []byte) {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil {
+		return
+	}
+	if m.Header.Truncated || (m.Header.RCode != dnsmessage.RCodeSuccess && m.Header.RCode != dnsmessage.RCodeNameError) {
+		return
+	}
+	ttl, ok := minTTL(&m)
+	if !ok || ttl == 0 {
+		return
+	}
+	now := time.Now()
+	item := &cacheItem{
+		msg:    append([]byte(nil), msg...),
+		stored: now,
+		expire: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= c.size {
+		c.evict(now)
+	}
+	c.items[cacheKey(q)] = item
+}
+
+// evict removes expired items, and arbitrary ones if the cache is still full.
+func (c *cache) evict(now time.Time) {
+	for k, item := range c.items {
+		if !now.Before(item.expire) {
+			delete(c.items, k)
+		}
+	}
+	for k := range c.items {
+		if len(c.items) < c.size {
+			break
+		}
+		delete(c.items, k)
+	}
+}
+
+// minTTL returns the smallest TTL of the answers, or of the SOA record for
+// negative answers.
+func minTTL(m *dnsmessage.Message) (ttl uint32, ok bool) {
+	records := m.Answers
+	if len(records) == 0 {
+		records = m.Authorities
+	}
+	for _, r := range records {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+		t := r.Header.TTL
+		if soa, isSOA := r.Body.(*dnsmessage.SOAResource); isSOA && soa.MinTTL < t {
+			t = soa.MinTTL
+		}
+		if !ok || t < ttl {
+			ttl, ok = t, true
+		}
+	}
+	return
+}
+
+func forEachResource(m *dnsmessage.Message, f func(h *dnsmessage.ResourceHeader)) {
+	for _, records := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range records {
+			if records[i].Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			f(&records[i].Header)
+		}
+	}
+}
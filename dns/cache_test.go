@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func question(name string) dnsmessage.Question {
+	return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+}
+
+// answerMsg returns an answer to q with an A record of each TTL.
+func answerMsg(t *testing.T, q dnsmessage.Question, id uint16, rcode dnsmessage.RCode, ttls ...uint32) []byte {
+	m := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, Response: true, RCode: rcode},
+		Questions: []dnsmessage.Question{q},
+	}
+	for i, ttl := range ttls {
+		m.Answers = append(m.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, byte(i + 1)}},
+		})
+	}
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func unpack(t *testing.T, b []byte) *dnsmessage.Message {
+	var m dnsmessage.Message
+	if err := m.Unpack(b); err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
+
+func TestCache(t *testing.T) {
+	c := newCache(DefaultCacheSize)
+	q := question("example.com.")
+	if c.get(q, 1) != nil {
+		t.Fatal("empty cache returned an answer")
+	}
+	c.put(q, answerMsg(t, q, 1, dnsmessage.RCodeSuccess, 300, 60))
+
+	// the name is case-insensitive, and the answer gets the ID of the query
+	b := c.get(question("EXAMPLE.com."), 42)
+	if b == nil {
+		t.Fatal("no cached answer")
+	}
+	if m := unpack(t, b); m.Header.ID != 42 || len(m.Answers) != 2 || m.Answers[0].Header.TTL != 300 {
+		t.Fatalf("unexpected answer: %+v", m)
+	}
+
+	// the TTLs are reduced by the time spent in the cache
+	c.items[cacheKey(q)].stored = time.Now().Add(-100 * time.Second)
+	m := unpack(t, c.get(q, 1))
+	if m.Answers[0].Header.TTL != 200 || m.Answers[1].Header.TTL != 0 {
+		t.Errorf("TTLs %v and %v, want 200 and 0", m.Answers[0].Header.TTL, m.Answers[1].Header.TTL)
+	}
+
+	// the answer expires after the smallest TTL
+	if expire := c.items[cacheKey(q)].expire; expire.After(time.Now().Add(60*time.Second)) || expire.Before(time.Now().Add(50*time.Second)) {
+		t.Errorf("expires at %v, want in 60s", expire)
+	}
+	c.items[cacheKey(q)].expire = time.Now()
+	if c.get(q, 1) != nil || len(c.items) != 0 {
+		t.Error("expired answer was returned")
+	}
+
+	// failures, truncated answers and answers without TTL are not cached
+	truncated := answerMsg(t, q, 1, dnsmessage.RCodeSuccess, 60)
+	truncated[2] |= 0x02
+	for _, msg := range [][]byte{
+		answerMsg(t, q, 1, dnsmessage.RCodeServerFailure, 60),
+		answerMsg(t, q, 1, dnsmessage.RCodeSuccess, 0),
+		answerMsg(t, q, 1, dnsmessage.RCodeSuccess),
+		truncated,
+		[]byte("garbage"),
+	} {
+		c.put(q, msg)
+		if c.get(q, 1) != nil {
+			t.Errorf("%x was cached", msg)
+		}
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	c := newCache(2)
+	a, b, d := question("a.example."), question("b.example."), question("d.example.")
+	c.put(a, answerMsg(t, a, 1, dnsmessage.RCodeSuccess, 60))
+	c.put(b, answerMsg(t, b, 1, dnsmessage.RCodeSuccess, 60))
+
+	// expired answers are evicted first
+	c.items[cacheKey(a)].expire = time.Now()
+	c.put(d, answerMsg(t, d, 1, dnsmessage.RCodeSuccess, 60))
+	if len(c.items) != 2 || c.get(b, 1) == nil || c.get(d, 1) == nil {
+		t.Fatalf("unexpected items: %v", c.items)
+	}
+
+	// then arbitrary ones
+	c.put(a, answerMsg(t, a, 1, dnsmessage.RCodeSuccess, 60))
+	if len(c.items) != 2 || c.get(a, 1) == nil {
+		t.Fatalf("unexpected items: %v", c.items)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	name := dnsmessage.MustNewName("example.com.")
+	soa := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+		Body:   &dnsmessage.SOAResource{NS: name, MBox: name, MinTTL: 900},
+	}
+	opt := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("."), Type: dnsmessage.TypeOPT, TTL: 1},
+		Body:   &dnsmessage.OPTResource{},
+	}
+	a := func(ttl uint32) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{},
+		}
+	}
+	for _, test := range []struct {
+		m   dnsmessage.Message
+		ttl uint32
+		ok  bool
+	}{
+		{dnsmessage.Message{Answers: []dnsmessage.Resource{a(300), a(60), a(120)}}, 60, true},
+		{dnsmessage.Message{Answers: []dnsmessage.Resource{opt, a(300)}}, 300, true},
+		// a negative answer is cached for the SOA minimum
+		{dnsmessage.Message{Authorities: []dnsmessage.Resource{soa}}, 900, true},
+		{dnsmessage.Message{Answers: []dnsmessage.Resource{a(30)}, Authorities: []dnsmessage.Resource{soa}}, 30, true},
+		{dnsmessage.Message{Additionals: []dnsmessage.Resource{a(30)}}, 0, false},
+	} {
+		if ttl, ok := minTTL(&test.m); ttl != test.ttl || ok != test.ok {
+			t.Errorf("got %v, %v, want %v, %v", ttl, ok, test.ttl, test.ok)
+		}
+	}
+}
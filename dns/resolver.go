@@ -0,0 +1,266 @@
+// Package dns resolves names through a proxy.Dialer such as client.SSR, so
+// that DNS queries do not leak outside the tunnel.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/proxy"
+)
+
+// DefaultTimeout is the timeout of a single query to an upstream server.
+const DefaultTimeout = 5 * time.Second
+
+// udpDialer is implemented by dialers that can relay UDP, such as client.SSR.
+type udpDialer interface {
+	DialUDP(network, addr string) (pc net.PacketConn, writeTo net.Addr, err error)
+}
+
+// udpDialerOf returns the udpDialer of d, or nil if d cannot relay UDP, as
+// reported by its SupportsUDP method if it has one.
+func udpDialerOf(d proxy.Dialer) udpDialer {
+	ud, ok := d.(udpDialer)
+	if !ok {
+		return nil
+	}
+	if s, ok := d.(interface{ SupportsUDP() bool }); ok && !s.SupportsUDP() {
+		return nil
+	}
+	return ud
+}
+
+// Resolver sends DNS queries to upstream servers through a dialer and caches
+// the answers until their TTL expires.
+type Resolver struct {
+	log     *logrus.Logger
+	dialer  proxy.Dialer
+	servers []string
+	cache   *cache
+
+	// Timeout is the timeout of a single query, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// NewResolver returns a resolver that queries servers, such as "8.8.8.8:53",
+// through d. Servers are tried in order until one of them answers.
+func NewResolver(servers []string, d proxy.Dialer, log *logrus.Logger) (*Resolver, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("[dns] no upstream server")
+	}
+	ss := make([]string, len(servers))
+	for i, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		ss[i] = s
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &Resolver{
+		log:     log,
+		dialer:  d,
+		servers: ss,
+		cache:   newCache(DefaultCacheSize),
+	}, nil
+}
+
+// Exchange sends the query msg in DNS wire format and returns the answer.
+// Cached answers are returned without contacting the upstream servers.
+func (r *Resolver) Exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, fmt.Errorf("[dns] parse query: %w", err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, fmt.Errorf("[dns] parse query: %w", err)
+	}
+	if b := r.cache.get(q, h.ID); b != nil {
+		return b, nil
+	}
+
+	for _, server := range r.servers {
+		var resp []byte
+		resp, err = r.exchange(ctx, server, msg)
+		if err != nil {
+			r.log.Warnf("[dns] query %v from %v: %v", q.Name, server, err)
+			continue
+		}
+		r.cache.put(q, resp)
+		return resp, nil
+	}
+	return nil, fmt.Errorf("[dns] query %v: %w", q.Name, err)
+}
+
+func (r *Resolver) exchange(ctx context.Context, server string, msg []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+	// queries go over TCP at once if the dialer cannot relay UDP
+	if ud := udpDialerOf(r.dialer); ud != nil {
+		resp, err := r.exchangeUDP(ctx, ud, server, msg)
+		if err == nil && !truncated(resp) {
+			return resp, nil
+		}
+	}
+	return r.exchangeTCP(ctx, server, msg)
+}
+
+func (r *Resolver) exchangeUDP(ctx context.Context, ud udpDialer, server string, msg []byte) ([]byte, error) {
+	pc, writeTo, err := ud.DialUDP("udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer pc.Close()
+	stop := closeOnDone(ctx, pc)
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		pc.SetDeadline(deadline)
+	}
+	if _, err = pc.WriteTo(msg, writeTo); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (r *Resolver) exchangeTCP(ctx context.Context, server string, msg []byte) ([]byte, error) {
+	c, err := r.dialer.Dial("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	stop := closeOnDone(ctx, c)
+	defer stop()
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	if err = writeMsg(c, msg); err != nil {
+		return nil, err
+	}
+	return readMsg(c)
+}
+
+// LookupHost looks up the given host through the resolver.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.NetResolver().LookupHost(ctx, host)
+}
+
+// NetResolver returns a net.Resolver that sends all of its queries through r,
+// regardless of the name servers configured on the system.
+func (r *Resolver) NetResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, s := net.Pipe()
+			go r.serveStream(ctx, s)
+			return c, nil
+		},
+	}
+}
+
+// serveStream answers length-prefixed queries read from c until c is closed.
+func (r *Resolver) serveStream(ctx context.Context, c net.Conn) {
+	defer c.Close()
+	for {
+		msg, err := readMsg(c)
+		if err != nil {
+			return
+		}
+		resp, err := r.Exchange(ctx, msg)
+		if err != nil {
+			if resp = serverFailure(msg); resp == nil {
+				return
+			}
+		}
+		if err = writeMsg(c, resp); err != nil {
+			return
+		}
+	}
+}
+
+func (r *Resolver) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultTimeout
+}
+
+// closeOnDone closes c when ctx is done before stop is called.
+func closeOnDone(ctx context.Context, c io.Closer) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func truncated(msg []byte) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	return err != nil || h.Truncated
+}
+
+// serverFailure returns a SERVFAIL answer to the query msg.
+func serverFailure(msg []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil
+	}
+	h.Response = true
+	h.RecursionAvailable = true
+	h.RCode = dnsmessage.RCodeServerFailure
+	resp, err := (&dnsmessage.Message{Header: h, Questions: questions}).Pack()
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// readMsg reads a DNS message with a 2-byte length prefix, as used over TCP.
+func readMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeMsg writes a DNS message with a 2-byte length prefix, as used over TCP.
+func writeMsg(w io.Writer, msg []byte) error {
+	if len(msg) > 0xFFFF {
+		return errors.New("[dns] message too long")
+	}
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	_, err := w.Write(b)
+	return err
+}
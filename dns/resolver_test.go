@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDialer dials directly, and counts the UDP dials. It relays UDP if udp
+// is set.
+type testDialer struct {
+	udp      bool
+	udpDials int32
+}
+
+func (d *testDialer) Dial(network, addr string) (net.Conn, error) {
+	return net.Dial(network, addr)
+}
+
+func (d *testDialer) DialUDP(network, addr string) (net.PacketConn, net.Addr, error) {
+	atomic.AddInt32(&d.udpDials, 1)
+	writeTo, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := net.ListenPacket(network, "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc, writeTo, nil
+}
+
+func (d *testDialer) SupportsUDP() bool {
+	return d.udp
+}
+
+// reply answers A queries with 10.0.0.1, and other queries with no record.
+func reply(query []byte) []byte {
+	var m dnsmessage.Message
+	if err := m.Unpack(query); err != nil || len(m.Questions) != 1 {
+		return nil
+	}
+	q := m.Questions[0]
+	m.Header.Response = true
+	m.Header.RecursionAvailable = true
+	m.Additionals = nil
+	if q.Type == dnsmessage.TypeA {
+		m.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}},
+		}}
+	}
+	b, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// listenDNS starts a DNS server over TCP and UDP on the same port, and counts
+// the queries it receives over each.
+func listenDNS(t *testing.T) (addr string, tcpQueries, udpQueries *int32, stop func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatal(err)
+	}
+	tcpQueries, udpQueries = new(int32), new(int32)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				for {
+					msg, err := readMsg(c)
+					if err != nil {
+						return
+					}
+					atomic.AddInt32(tcpQueries, 1)
+					if writeMsg(c, reply(msg)) != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(udpQueries, 1)
+			pc.WriteTo(reply(buf[:n]), addr)
+		}
+	}()
+	return l.Addr().String(), tcpQueries, udpQueries, func() {
+		l.Close()
+		pc.Close()
+	}
+}
+
+func TestResolver(t *testing.T) {
+	addr, tcpQueries, udpQueries, stop := listenDNS(t)
+	defer stop()
+
+	for _, udp := range []bool{false, true} {
+		atomic.StoreInt32(tcpQueries, 0)
+		atomic.StoreInt32(udpQueries, 0)
+		d := &testDialer{udp: udp}
+		r, err := NewResolver([]string{addr}, d, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for i := 0; i < 2; i++ {
+			// the queries of the net.Resolver go over the stream path
+			addrs, err := r.NetResolver().LookupIPAddr(ctx, "example.com")
+			if err != nil {
+				t.Fatalf("udp %v: %v", udp, err)
+			}
+			if len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(10, 0, 0, 1)) {
+				t.Fatalf("udp %v: unexpected addresses %v", udp, addrs)
+			}
+		}
+		cancel()
+
+		queries, udpDials := atomic.LoadInt32(tcpQueries), atomic.LoadInt32(&d.udpDials)
+		if udp {
+			queries = atomic.LoadInt32(udpQueries)
+		}
+		// the A answer is cached, the empty AAAA answer is not
+		if queries != 3 {
+			t.Errorf("udp %v: %d queries to the server, want 3", udp, queries)
+		}
+		if !udp && udpDials != 0 {
+			t.Errorf("udp was dialed %d times without udp support", udpDials)
+		}
+		if udp && atomic.LoadInt32(tcpQueries) != 0 {
+			t.Errorf("%d queries over tcp with udp support", atomic.LoadInt32(tcpQueries))
+		}
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	if _, err := NewResolver(nil, &testDialer{}, nil); err == nil {
+		t.Fatal("expected an error without server")
+	}
+	r, err := NewResolver([]string{"8.8.8.8", "[2001:4860:4860::8888]:5353"}, &testDialer{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"8.8.8.8:53", "[2001:4860:4860::8888]:5353"}; !reflect.DeepEqual(r.servers, want) {
+		t.Errorf("servers %v, want %v", r.servers, want)
+	}
+}
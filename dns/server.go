@@ -0,0 +1,61 @@
+package dns
+
+import (
+	"context"
+	"net"
+)
+
+// ListenAndServe answers DNS queries received over both UDP and TCP on addr
+// through r. It returns when either listener fails.
+func (r *Resolver) ListenAndServe(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	errCh := make(chan error, 2)
+	go func() { errCh <- r.ServeUDP(pc) }()
+	go func() { errCh <- r.ServeTCP(l) }()
+	err = <-errCh
+	pc.Close()
+	l.Close()
+	return err
+}
+
+// ServeUDP answers DNS queries received on pc until pc is closed.
+func (r *Resolver) ServeUDP(pc net.PacketConn) error {
+	defer pc.Close()
+	for {
+		buf := make([]byte, 65535)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		go func(msg []byte, addr net.Addr) {
+			resp, err := r.Exchange(context.Background(), msg)
+			if err != nil {
+				if resp = serverFailure(msg); resp == nil {
+					return
+				}
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}(buf[:n], addr)
+	}
+}
+
+// ServeTCP answers DNS queries received on connections accepted from l until
+// l is closed.
+func (r *Resolver) ServeTCP(l net.Listener) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go r.serveStream(context.Background(), c)
+	}
+}
@@ -0,0 +1,145 @@
+package inbound
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"golang.org/x/net/proxy"
+)
+
+// HTTP is an HTTP proxy inbound. CONNECT requests are tunneled through the
+// dialer, and requests with an absolute URI are forwarded to the origin server.
+type HTTP struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+
+	// Username and Password enable basic authentication when Username is not empty.
+	Username string
+	Password string
+
+	reverseProxy *httputil.ReverseProxy
+}
+
+// NewHTTP returns an HTTP proxy inbound that dials through d.
+func NewHTTP(d proxy.Dialer, log *logrus.Logger) *HTTP {
+	h := &HTTP{
+		log:    newLogger(log),
+		dialer: d,
+	}
+	h.reverseProxy = &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			// the proxy is expected to be transparent
+			r.Header["X-Forwarded-For"] = nil
+			if _, ok := r.Header["User-Agent"]; !ok {
+				r.Header.Set("User-Agent", "")
+			}
+		},
+		Transport: &http.Transport{
+			Dial:              d.Dial,
+			DisableKeepAlives: true,
+		},
+	}
+	return h
+}
+
+// ListenAndServe listens on the TCP network address addr and serves proxy requests.
+func (h *HTTP) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return h.Serve(l)
+}
+
+// Serve accepts connections on l and serves proxy requests until l is closed.
+func (h *HTTP) Serve(l net.Listener) error {
+	return (&http.Server{Handler: h}).Serve(l)
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="shadowsocksR"`)
+		http.Error(w, http.StatusText(http.StatusProxyAuthRequired), http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		h.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "absolute http URI is required", http.StatusBadRequest)
+		return
+	}
+	h.log.Infof("[http] %v <-> %v", r.RemoteAddr, r.URL.Host)
+	h.reverseProxy.ServeHTTP(w, r)
+}
+
+func (h *HTTP) authorized(r *http.Request) bool {
+	if h.Username == "" {
+		return true
+	}
+	auth := r.Header.Get("Proxy-Authorization")
+	const prefix = "Basic "
+	if len(auth) < len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	cred, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return false
+	}
+	i := bytes.IndexByte(cred, ':')
+	if i < 0 {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare(cred[:i], []byte(h.Username)) == 1
+	passOK := subtle.ConstantTimeCompare(cred[i+1:], []byte(h.Password)) == 1
+	return userOK && passOK
+}
+
+func (h *HTTP) serveConnect(w http.ResponseWriter, r *http.Request) {
+	target := r.Host
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, "443")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+	rc, err := h.dialer.Dial("tcp", target)
+	if err != nil {
+		h.log.Warnf("[http] failed to connect to %v: %v", target, err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	c, brw, err := hijacker.Hijack()
+	if err != nil {
+		rc.Close()
+		h.log.Warnf("[http] hijack: %v", err)
+		return
+	}
+	if _, err = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		c.Close()
+		rc.Close()
+		return
+	}
+	// the client may have sent data right after the request header
+	if n := brw.Reader.Buffered(); n > 0 {
+		b, _ := brw.Reader.Peek(n)
+		if _, err = rc.Write(b); err != nil {
+			c.Close()
+			rc.Close()
+			return
+		}
+	}
+	h.log.Infof("[http] %v <-> %v", c.RemoteAddr(), target)
+	tools.Relay(c, rc)
+}
@@ -0,0 +1,129 @@
+package inbound
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"golang.org/x/net/proxy"
+)
+
+// listenHTTP starts an HTTP proxy inbound with basic authentication.
+func listenHTTP(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHTTP(proxy.Direct, nil)
+	h.Username, h.Password = "user", "pass"
+	go h.Serve(l)
+	return l
+}
+
+func TestHTTPConnect(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	l := listenHTTP(t)
+	defer l.Close()
+
+	for _, test := range []struct {
+		auth   string
+		status int
+	}{
+		{"", http.StatusProxyAuthRequired},
+		{"user:wrong", http.StatusProxyAuthRequired},
+		{"user:pass", http.StatusOK},
+	} {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		req := "CONNECT " + echo.Addr().String() + " HTTP/1.1\r\nHost: " + echo.Addr().String() + "\r\n"
+		if test.auth != "" {
+			req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(test.auth)) + "\r\n"
+		}
+		// the data sent along with the request is forwarded too
+		msg := "Don't tell me the moon is shining"
+		if _, err = io.WriteString(c, req+"\r\n"+msg); err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(c)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.status {
+			t.Errorf("%q: status %v, want %v", test.auth, resp.StatusCode, test.status)
+		}
+		if resp.StatusCode == http.StatusOK {
+			buf := make([]byte, len(msg))
+			if _, err = io.ReadFull(br, buf); err != nil {
+				t.Fatal(err)
+			}
+			if string(buf) != msg {
+				t.Errorf("unexpected echo\n\texpect: %q\n\tgot:    %q", msg, buf)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestHTTPForward(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" || r.Header.Get("X-Forwarded-For") != "" {
+			t.Errorf("the proxy headers are forwarded: %v", r.Header)
+		}
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer origin.Close()
+	l := listenHTTP(t)
+	defer l.Close()
+
+	get := func(user *url.Userinfo, target string) (int, string) {
+		client := &http.Client{
+			Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", User: user, Host: l.Addr().String()})},
+			Timeout:   5 * time.Second,
+		}
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(b)
+	}
+	if status, _ := get(nil, origin.URL+"/moon"); status != http.StatusProxyAuthRequired {
+		t.Errorf("without credentials: status %v", status)
+	}
+	if status, body := get(url.UserPassword("user", "pass"), origin.URL+"/moon"); status != http.StatusOK || body != "hello /moon" {
+		t.Errorf("got %v %q", status, body)
+	}
+
+	// a request for the proxy itself is not forwarded
+	req, err := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("user", "pass")
+	req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("relative URI: status %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,113 @@
+package inbound
+
+import (
+	"errors"
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// OriginalDstResolver recovers the destination that a redirected connection
+// was originally addressed to.
+type OriginalDstResolver func(c net.Conn) (net.Addr, error)
+
+// Redir is a transparent proxy inbound for connections redirected by
+// iptables REDIRECT or TPROXY rules.
+type Redir struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+
+	// Resolve recovers the original destination of accepted connections.
+	Resolve OriginalDstResolver
+	// Transparent makes ListenAndServe set IP_TRANSPARENT on the listener,
+	// which is required for TPROXY.
+	Transparent bool
+}
+
+// NewRedir returns an inbound for connections redirected by iptables REDIRECT.
+// The original destination is read with SO_ORIGINAL_DST.
+func NewRedir(d proxy.Dialer, log *logrus.Logger) *Redir {
+	return &Redir{
+		log:     newLogger(log),
+		dialer:  d,
+		Resolve: GetOriginalDst,
+	}
+}
+
+// NewTProxy returns an inbound for connections redirected by iptables TPROXY.
+// The original destination is the local address of the accepted connection.
+func NewTProxy(d proxy.Dialer, log *logrus.Logger) *Redir {
+	return &Redir{
+		log:         newLogger(log),
+		dialer:      d,
+		Resolve:     GetTProxyDst,
+		Transparent: true,
+	}
+}
+
+// GetTProxyDst returns the original destination of a connection accepted on
+// a TPROXY listener, which is its local address.
+func GetTProxyDst(c net.Conn) (net.Addr, error) {
+	addr := c.LocalAddr()
+	if addr == nil {
+		return nil, errors.New("[redir] nil local address")
+	}
+	return addr, nil
+}
+
+// ListenAndServe listens on the TCP network address addr and serves
+// redirected connections.
+func (r *Redir) ListenAndServe(addr string) error {
+	var (
+		l   net.Listener
+		err error
+	)
+	if r.Transparent {
+		l, err = listenTransparent(addr)
+	} else {
+		l, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	return r.Serve(l)
+}
+
+// Serve accepts connections on l and serves them until l is closed.
+func (r *Redir) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go r.ServeConn(c)
+	}
+}
+
+// ServeConn relays a single redirected connection to its original destination.
+func (r *Redir) ServeConn(c net.Conn) {
+	dst, err := r.Resolve(c)
+	if err != nil {
+		r.log.Warnf("[redir] failed to get original destination of %v: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	target := socks.ParseAddr(dst.String())
+	if target == nil {
+		r.log.Warnf("[redir] unable to parse address: %v", dst)
+		c.Close()
+		return
+	}
+	rc, err := r.dialer.Dial("tcp", target.String())
+	if err != nil {
+		r.log.Warnf("[redir] failed to connect to %v: %v", target, err)
+		c.Close()
+		return
+	}
+	r.log.Infof("[redir] %v <-> %v", c.RemoteAddr(), target)
+	tools.Relay(c, rc)
+}
@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+package inbound
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/v2rayA/shadowsocksR/tools"
+	"golang.org/x/sys/unix"
+)
+
+// SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from linux/netfilter_ipv4.h and
+// linux/netfilter_ipv6/ip6_tables.h
+const (
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+// GetOriginalDst returns the original destination of a connection redirected
+// by iptables REDIRECT.
+func GetOriginalDst(c net.Conn) (net.Addr, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("[redir] only tcp connections are supported")
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		addr  *net.TCPAddr
+		opErr error
+	)
+	isIPv6 := tc.LocalAddr().(*net.TCPAddr).IP.To4() == nil
+	err = rc.Control(func(fd uintptr) {
+		if isIPv6 {
+			var info *unix.IPv6MTUInfo
+			info, opErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.IPPROTO_IPV6, ip6tSoOriginalDst)
+			if opErr != nil {
+				return
+			}
+			addr = &net.TCPAddr{
+				IP:   append(net.IP(nil), info.Addr.Addr[:]...),
+				Port: int(ntohs(info.Addr.Port)),
+			}
+			return
+		}
+		var mreq *unix.IPv6Mreq
+		mreq, opErr = unix.GetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IP, soOriginalDst)
+		if opErr != nil {
+			return
+		}
+		// the returned buffer holds a struct sockaddr_in
+		addr = &net.TCPAddr{
+			IP:   net.IPv4(mreq.Multiaddr[4], mreq.Multiaddr[5], mreq.Multiaddr[6], mreq.Multiaddr[7]),
+			Port: int(binary.BigEndian.Uint16(mreq.Multiaddr[2:4])),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if opErr != nil {
+		return nil, opErr
+	}
+	return addr, nil
+}
+
+func ntohs(port uint16) uint16 {
+	if tools.IsLittleEndian() {
+		return port>>8 | port<<8
+	}
+	return port
+}
+
+func listenTransparent(addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				if opErr == nil && network == "tcp6" {
+					opErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		},
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
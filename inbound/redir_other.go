@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package inbound
+
+import (
+	"errors"
+	"net"
+)
+
+// GetOriginalDst returns the original destination of a connection redirected
+// by iptables REDIRECT. It is only supported on Linux.
+func GetOriginalDst(c net.Conn) (net.Addr, error) {
+	return nil, errors.New("[redir] SO_ORIGINAL_DST is only supported on linux")
+}
+
+func listenTransparent(addr string) (net.Listener, error) {
+	return nil, errors.New("[redir] IP_TRANSPARENT is only supported on linux")
+}
@@ -0,0 +1,47 @@
+package inbound
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"golang.org/x/net/proxy"
+)
+
+func TestRedir(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRedir(proxy.Direct, nil)
+	r.Resolve = func(c net.Conn) (net.Addr, error) {
+		return echo.Addr(), nil
+	}
+	go r.Serve(l)
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	msg := []byte("Don't tell me the moon is shining")
+	if _, err = c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("unexpected echo\n\texpect: %q\n\tgot:    %q", msg, buf)
+	}
+}
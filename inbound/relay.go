@@ -0,0 +1,15 @@
+// Package inbound implements local proxy servers that forward connections
+// through a proxy.Dialer such as client.SSR.
+package inbound
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+)
+
+func newLogger(log *logrus.Logger) *logrus.Logger {
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return log
+}
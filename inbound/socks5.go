@@ -0,0 +1,201 @@
+package inbound
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+const socks5Version = 5
+
+// SOCKS5 methods, commands and replies as defined in RFC 1928 and RFC 1929.
+const (
+	socks5MethodNoAuth       = 0
+	socks5MethodUserPass     = 2
+	socks5MethodNoAcceptable = 0xff
+
+	socks5CmdConnect = 1
+
+	socks5ReplySucceeded           = 0
+	socks5ReplyHostUnreachable     = 4
+	socks5ReplyCommandNotSupported = 7
+	socks5ReplyAddressNotSupported = 8
+)
+
+// socks5HandshakeTimeout bounds the negotiation with a client.
+const socks5HandshakeTimeout = 30 * time.Second
+
+// SOCKS5 is a SOCKS5 proxy inbound. Only the CONNECT command is supported.
+type SOCKS5 struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+
+	// Username and Password enable username/password authentication when
+	// Username is not empty.
+	Username string
+	Password string
+}
+
+// NewSOCKS5 returns a SOCKS5 proxy inbound that dials through d.
+func NewSOCKS5(d proxy.Dialer, log *logrus.Logger) *SOCKS5 {
+	return &SOCKS5{
+		log:    newLogger(log),
+		dialer: d,
+	}
+}
+
+// ListenAndServe listens on the TCP network address addr and serves proxy requests.
+func (s *SOCKS5) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves them until l is closed.
+func (s *SOCKS5) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves a single SOCKS5 connection.
+func (s *SOCKS5) ServeConn(c net.Conn) {
+	c.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	target, err := s.handshake(c)
+	if err != nil {
+		s.log.Warnf("[socks5] %v: %v", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	rc, err := s.dialer.Dial("tcp", target.String())
+	if err != nil {
+		s.log.Warnf("[socks5] failed to connect to %v: %v", target, err)
+		s.reply(c, socks5ReplyHostUnreachable, nil)
+		c.Close()
+		return
+	}
+	if err = s.reply(c, socks5ReplySucceeded, rc.LocalAddr()); err != nil {
+		rc.Close()
+		c.Close()
+		return
+	}
+	c.SetDeadline(time.Time{})
+	s.log.Infof("[socks5] %v <-> %v", c.RemoteAddr(), target)
+	tools.Relay(c, rc)
+}
+
+// handshake negotiates the method and reads the request of the client.
+func (s *SOCKS5) handshake(c net.Conn) (socks.Addr, error) {
+	// version, number of methods, methods
+	buf := make([]byte, 256+2)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, fmt.Errorf("unsupported version: %d", buf[0])
+	}
+	methods := buf[2 : 2+int(buf[1])]
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return nil, err
+	}
+	method := byte(socks5MethodNoAuth)
+	if s.Username != "" {
+		method = socks5MethodUserPass
+	}
+	if !containsByte(methods, method) {
+		c.Write([]byte{socks5Version, socks5MethodNoAcceptable})
+		return nil, errors.New("no acceptable authentication method")
+	}
+	if _, err := c.Write([]byte{socks5Version, method}); err != nil {
+		return nil, err
+	}
+	if method == socks5MethodUserPass {
+		if err := s.authenticate(c); err != nil {
+			return nil, err
+		}
+	}
+
+	// version, command, reserved, address
+	if _, err := io.ReadFull(c, buf[:3]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socks5Version {
+		return nil, fmt.Errorf("unsupported version: %d", buf[0])
+	}
+	cmd := buf[1]
+	target, err := socks.ReadAddr(c)
+	if err != nil {
+		s.reply(c, socks5ReplyAddressNotSupported, nil)
+		return nil, err
+	}
+	if cmd != socks5CmdConnect {
+		s.reply(c, socks5ReplyCommandNotSupported, nil)
+		return nil, fmt.Errorf("unsupported command: %d", cmd)
+	}
+	return target, nil
+}
+
+// authenticate checks the username and password of the client (RFC 1929).
+func (s *SOCKS5) authenticate(c net.Conn) error {
+	buf := make([]byte, 255)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != 1 {
+		return fmt.Errorf("unsupported authentication version: %d", buf[0])
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c, buf[:1]); err != nil {
+		return err
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(user, []byte(s.Username)) != 1 ||
+		subtle.ConstantTimeCompare(pass, []byte(s.Password)) != 1 {
+		c.Write([]byte{1, 1})
+		return errors.New("authentication failed")
+	}
+	_, err := c.Write([]byte{1, 0})
+	return err
+}
+
+// reply sends a reply with the bound address addr, or an empty IPv4 address.
+func (s *SOCKS5) reply(c net.Conn, rep byte, addr net.Addr) error {
+	bound := socks.Addr{socks.AtypIPv4, 0, 0, 0, 0, 0, 0}
+	if addr != nil {
+		if a := socks.ParseAddr(addr.String()); a != nil {
+			bound = a
+		}
+	}
+	_, err := c.Write(append([]byte{socks5Version, rep, 0}, bound...))
+	return err
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, x := range b {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
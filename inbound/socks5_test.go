@@ -0,0 +1,58 @@
+package inbound
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"golang.org/x/net/proxy"
+)
+
+func TestSOCKS5(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSOCKS5(proxy.Direct, nil)
+	s.Username, s.Password = "user", "pass"
+	go s.Serve(l)
+	defer l.Close()
+
+	wrong, err := proxy.SOCKS5("tcp", l.Addr().String(), &proxy.Auth{User: "user", Password: "wrong"}, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := wrong.Dial("tcp", echo.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("expected an authentication failure")
+	}
+
+	d, err := proxy.SOCKS5("tcp", l.Addr().String(), &proxy.Auth{User: "user", Password: "pass"}, proxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	msg := []byte("Don't tell me the moon is shining")
+	if _, err = c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("unexpected echo\n\texpect: %q\n\tgot:    %q", msg, buf)
+	}
+}
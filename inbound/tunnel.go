@@ -0,0 +1,247 @@
+package inbound
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/leakybuf"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// DefaultUDPTimeout is how long a UDP session of a Tunnel is kept without traffic.
+const DefaultUDPTimeout = 60 * time.Second
+
+// UDPDialer is implemented by dialers that can relay UDP, such as client.SSR.
+// Datagrams for addr are sent with pc.WriteTo(b, writeTo), and replies from
+// addr are read with pc.ReadFrom.
+type UDPDialer interface {
+	DialUDP(network, addr string) (pc net.PacketConn, writeTo net.Addr, err error)
+}
+
+// udpDialerOf returns the UDPDialer of d, or nil if d cannot relay UDP. A
+// dialer with a SupportsUDP method, such as client.SSR, may have DialUDP and
+// still not support it.
+func udpDialerOf(d proxy.Dialer) UDPDialer {
+	ud, ok := d.(UDPDialer)
+	if !ok {
+		return nil
+	}
+	if s, ok := d.(interface{ SupportsUDP() bool }); ok && !s.SupportsUDP() {
+		return nil
+	}
+	return ud
+}
+
+// ErrUDPUnsupported is returned by ServeUDP if the dialer cannot relay UDP.
+var ErrUDPUnsupported = errors.New("[tunnel] the dialer does not support udp")
+
+// Tunnel forwards every accepted connection to a fixed destination, like
+// ss-tunnel -L.
+type Tunnel struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+	target socks.Addr
+
+	// UDPTimeout is the idle timeout of UDP sessions, DefaultUDPTimeout if zero.
+	UDPTimeout time.Duration
+}
+
+// NewTunnel returns a tunnel inbound that forwards to target through d.
+func NewTunnel(target string, d proxy.Dialer, log *logrus.Logger) (*Tunnel, error) {
+	tgt := socks.ParseAddr(target)
+	if tgt == nil {
+		return nil, errors.New("[tunnel] unable to parse address: " + target)
+	}
+	return &Tunnel{
+		log:    newLogger(log),
+		dialer: d,
+		target: tgt,
+	}, nil
+}
+
+// Target returns the destination of the tunnel.
+func (t *Tunnel) Target() string {
+	return t.target.String()
+}
+
+// ListenAndServe listens on the TCP network address addr and forwards
+// accepted connections.
+func (t *Tunnel) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return t.Serve(l)
+}
+
+// Serve accepts connections on l and forwards them until l is closed.
+func (t *Tunnel) Serve(l net.Listener) error {
+	defer l.Close()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go t.ServeConn(c)
+	}
+}
+
+// ServeConn forwards a single connection to the destination of the tunnel.
+func (t *Tunnel) ServeConn(c net.Conn) {
+	rc, err := t.dialer.Dial("tcp", t.target.String())
+	if err != nil {
+		t.log.Warnf("[tunnel] failed to connect to %v: %v", t.target, err)
+		c.Close()
+		return
+	}
+	t.log.Infof("[tunnel] %v <-> %v", c.RemoteAddr(), t.target)
+	tools.Relay(c, rc)
+}
+
+// ListenAndServeUDP listens on the UDP network address addr and forwards
+// datagrams. The dialer of the tunnel must be able to relay UDP.
+func (t *Tunnel) ListenAndServeUDP(addr string) error {
+	if udpDialerOf(t.dialer) == nil {
+		return ErrUDPUnsupported
+	}
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return t.ServeUDP(pc)
+}
+
+// ServeUDP forwards datagrams received on pc until pc is closed. Each client
+// address gets its own session, which is closed after UDPTimeout of inactivity.
+// It returns ErrUDPUnsupported at once if the dialer cannot relay UDP.
+func (t *Tunnel) ServeUDP(pc net.PacketConn) error {
+	defer pc.Close()
+	ud := udpDialerOf(t.dialer)
+	if ud == nil {
+		return ErrUDPUnsupported
+	}
+	sessions := &udpSessions{m: make(map[string]*udpSession)}
+	buf := leakybuf.GlobalLeakyBuf.Get()
+	defer leakybuf.GlobalLeakyBuf.Put(buf)
+	for {
+		n, clientAddr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		key := clientAddr.String()
+		for {
+			s := sessions.get(key)
+			if s == nil {
+				rpc, writeTo, err := ud.DialUDP("udp", t.target.String())
+				if err != nil {
+					t.log.Warnf("[tunnel] failed to connect to %v: %v", t.target, err)
+					break
+				}
+				s = &udpSession{PacketConn: rpc, writeTo: writeTo}
+				sessions.add(key, s)
+				t.log.Infof("[tunnel] udp %v <-> %v", clientAddr, t.target)
+				go t.copyUDP(pc, clientAddr, sessions, key, s)
+			}
+			// the session may have expired since it was looked up
+			ok, err := sessions.send(key, s, buf[:n], t.udpTimeout())
+			if err != nil {
+				t.log.Warnf("[tunnel] udp write to %v: %v", t.target, err)
+			}
+			if ok {
+				break
+			}
+		}
+	}
+}
+
+// udpSessions are the sessions of ServeUDP by client address. A session is
+// written to and removed under mu, so that it is never written after Close.
+type udpSessions struct {
+	mu sync.Mutex
+	m  map[string]*udpSession
+}
+
+type udpSession struct {
+	net.PacketConn
+	writeTo net.Addr
+	// last is the time of the last datagram from the client
+	last time.Time
+}
+
+func (ss *udpSessions) get(key string) *udpSession {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.m[key]
+}
+
+func (ss *udpSessions) add(key string, s *udpSession) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s.last = time.Now()
+	ss.m[key] = s
+}
+
+// send writes b to the target of s, and extends its idle timeout. It returns
+// false if s is no longer the session of key.
+func (ss *udpSessions) send(key string, s *udpSession, b []byte, timeout time.Duration) (bool, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.m[key] != s {
+		return false, nil
+	}
+	s.last = time.Now()
+	s.SetReadDeadline(s.last.Add(timeout))
+	_, err := s.WriteTo(b, s.writeTo)
+	return true, err
+}
+
+// expire removes and closes s unless it received a datagram within timeout,
+// or unless force is set. It reports whether s was closed.
+func (ss *udpSessions) expire(key string, s *udpSession, timeout time.Duration, force bool) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if !force && time.Since(s.last) < timeout {
+		return false
+	}
+	if ss.m[key] == s {
+		delete(ss.m, key)
+	}
+	s.Close()
+	return true
+}
+
+// copyUDP sends replies from the session back to the client until the
+// session times out.
+func (t *Tunnel) copyUDP(pc net.PacketConn, clientAddr net.Addr, sessions *udpSessions, key string, s *udpSession) {
+	buf := leakybuf.GlobalLeakyBuf.Get()
+	defer leakybuf.GlobalLeakyBuf.Put(buf)
+	timeout := t.udpTimeout()
+	for {
+		s.SetReadDeadline(time.Now().Add(timeout))
+		n, _, err := s.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			timedOut := errors.As(err, &ne) && ne.Timeout()
+			if sessions.expire(key, s, timeout, !timedOut) {
+				return
+			}
+			continue
+		}
+		if _, err = pc.WriteTo(buf[:n], clientAddr); err != nil {
+			sessions.expire(key, s, timeout, true)
+			return
+		}
+	}
+}
+
+func (t *Tunnel) udpTimeout() time.Duration {
+	if t.UDPTimeout > 0 {
+		return t.UDPTimeout
+	}
+	return DefaultUDPTimeout
+}
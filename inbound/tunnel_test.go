@@ -0,0 +1,167 @@
+package inbound
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+)
+
+// udpDirect is a direct dialer that relays UDP if udp is set.
+type udpDirect struct {
+	udp bool
+}
+
+func (d *udpDirect) Dial(network, addr string) (net.Conn, error) {
+	return net.Dial(network, addr)
+}
+
+func (d *udpDirect) DialUDP(network, addr string) (net.PacketConn, net.Addr, error) {
+	writeTo, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	pc, err := net.ListenPacket(network, "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc, writeTo, nil
+}
+
+func (d *udpDirect) SupportsUDP() bool {
+	return d.udp
+}
+
+// listenUDPEcho starts a UDP server that echoes the datagrams it receives.
+func listenUDPEcho(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+	return pc
+}
+
+func TestTunnel(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+	tun, err := NewTunnel(echo.Addr().String(), &udpDirect{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tun.Serve(l)
+	defer l.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := []byte("Don't tell me the moon is shining")
+	if _, err = c.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Errorf("unexpected echo\n\texpect: %q\n\tgot:    %q", msg, buf)
+	}
+
+	if _, err = NewTunnel("no port", &udpDirect{}, nil); err == nil {
+		t.Error("expected an error for an invalid target")
+	}
+}
+
+func TestTunnelUDP(t *testing.T) {
+	echo := listenUDPEcho(t)
+	defer echo.Close()
+
+	// a dialer that cannot relay UDP is refused before serving
+	tun, err := NewTunnel(echo.LocalAddr().String(), &udpDirect{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tun.ListenAndServeUDP("127.0.0.1:0"); err != ErrUDPUnsupported {
+		t.Fatalf("got %v, want ErrUDPUnsupported", err)
+	}
+
+	tun, err = NewTunnel(echo.LocalAddr().String(), &udpDirect{udp: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.UDPTimeout = 100 * time.Millisecond
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go tun.ServeUDP(pc)
+	defer pc.Close()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buf := make([]byte, 2048)
+	// the session expires between the rounds, and is opened again
+	for i, delay := range []time.Duration{0, 10 * time.Millisecond, 300 * time.Millisecond, 0} {
+		time.Sleep(delay)
+		msg := []byte{byte(i), 'p', 'i', 'n', 'g'}
+		if _, err = c.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatalf("round %d: %v", i, err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Errorf("round %d: got %q, want %q", i, buf[:n], msg)
+		}
+	}
+}
+
+// TestUDPSessions checks that an expired session is never written to.
+func TestUDPSessions(t *testing.T) {
+	ss := &udpSessions{m: make(map[string]*udpSession)}
+	pc, writeTo, err := (&udpDirect{}).DialUDP("udp", "127.0.0.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &udpSession{PacketConn: pc, writeTo: writeTo}
+	ss.add("client", s)
+	if ss.expire("client", s, time.Minute, false) {
+		t.Fatal("an active session expired")
+	}
+	if ok, err := ss.send("client", s, []byte("ping"), time.Minute); !ok || err != nil {
+		t.Fatalf("got %v, %v", ok, err)
+	}
+	if !ss.expire("client", s, 0, false) {
+		t.Fatal("an idle session did not expire")
+	}
+	if ok, err := ss.send("client", s, []byte("ping"), time.Minute); ok || err != nil {
+		t.Fatalf("write to an expired session: %v, %v", ok, err)
+	}
+	if ss.get("client") != nil {
+		t.Fatal("the expired session was not removed")
+	}
+}
@@ -0,0 +1,77 @@
+// Package testutil holds the fixtures shared by the tests of the module.
+package testutil
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// ListenEcho starts a TCP server that echoes what it receives.
+func ListenEcho(t *testing.T) net.Listener {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return echo
+}
+
+// EchoThrough checks that c is connected to an echo server. The message is
+// written while it is read back, and is long enough to be split by the
+// transports.
+func EchoThrough(t *testing.T, c net.Conn) {
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	msg := bytes.Repeat([]byte("Don't tell me the moon is shining"), 1000)
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write(msg)
+		written <- err
+	}()
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatal("unexpected echo")
+	}
+}
+
+// ShortWriteConn writes at most Max bytes per Write, and fails with Err
+// while Failures is positive.
+type ShortWriteConn struct {
+	net.Conn
+	Max      int
+	Failures int
+	Err      error
+}
+
+func (c *ShortWriteConn) Write(b []byte) (int, error) {
+	if c.Failures > 0 {
+		c.Failures--
+		return 0, c.Err
+	}
+	if c.Max == 0 {
+		return 0, nil
+	}
+	if len(b) > c.Max {
+		b = b[:c.Max]
+	}
+	return c.Conn.Write(b)
+}
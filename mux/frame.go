@@ -0,0 +1,61 @@
+// Package mux multiplexes logical streams over a single connection, such as
+// an SSR connection, so that new streams need no extra TCP connection and
+// obfs or protocol handshake.
+//
+// The framing follows smux v1: every frame starts with an 8-byte header made
+// of version, command, little-endian payload length and stream id.
+package mux
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	version = 1
+
+	headerSize = 8
+	// maxPayload is the largest payload carried by a single frame.
+	maxPayload = 0xFFFF
+)
+
+const (
+	cmdSYN byte = iota // open a stream
+	cmdFIN             // close the write side of a stream
+	cmdPSH             // data
+	cmdNOP             // keepalive
+)
+
+type header [headerSize]byte
+
+func (h header) version() byte {
+	return h[0]
+}
+
+func (h header) cmd() byte {
+	return h[1]
+}
+
+func (h header) length() int {
+	return int(binary.LittleEndian.Uint16(h[2:]))
+}
+
+func (h header) streamID() uint32 {
+	return binary.LittleEndian.Uint32(h[4:])
+}
+
+func (h header) String() string {
+	return fmt.Sprintf("version:%d cmd:%d stream:%d length:%d", h.version(), h.cmd(), h.streamID(), h.length())
+}
+
+// newFrame returns a frame of cmd for stream id carrying data, which must not
+// be longer than maxPayload.
+func newFrame(cmd byte, id uint32, data []byte) []byte {
+	b := make([]byte, headerSize+len(data))
+	b[0] = version
+	b[1] = cmd
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(data)))
+	binary.LittleEndian.PutUint32(b[4:], id)
+	copy(b[headerSize:], data)
+	return b
+}
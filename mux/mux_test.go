@@ -0,0 +1,220 @@
+package mux
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// pipeDialer serves every dialed connection with Serve.
+type pipeDialer struct {
+	dials int
+}
+
+func (d *pipeDialer) Dial(network, addr string) (net.Conn, error) {
+	if addr != Addr {
+		return nil, fmt.Errorf("unexpected address: %v", addr)
+	}
+	d.dials++
+	c, s := net.Pipe()
+	go Serve(s, proxy.Direct, nil)
+	return c, nil
+}
+
+func echoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestPool(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+
+	d := &pipeDialer{}
+	p := NewPool(d, nil)
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := p.Dial("tcp", echo.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+
+			msg := make([]byte, 100*1024)
+			rand.Read(msg)
+			go c.Write(msg)
+			buf := make([]byte, len(msg))
+			if _, err = io.ReadFull(c, buf); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(buf, msg) {
+				t.Error("echo does not match")
+			}
+		}()
+	}
+	wg.Wait()
+	if d.dials != 1 {
+		t.Errorf("expect 1 session, got %d", d.dials)
+	}
+}
+
+func TestStreamHalfClose(t *testing.T) {
+	c, s := net.Pipe()
+	client, server := Client(c), Server(s)
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = st.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(peer)
+	if err != nil || string(b) != "ping" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	if _, err = peer.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	peer.Close()
+	b, err = io.ReadAll(st)
+	if err != nil || string(b) != "pong" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+}
+
+// TestStreamSessionDies checks that a stream cut short by the loss of the
+// underlying connection does not read as a clean EOF.
+func TestStreamSessionDies(t *testing.T) {
+	c, s := net.Pipe()
+	client, server := Client(c), Server(s)
+	defer client.Close()
+	defer server.Close()
+
+	st, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = peer.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(st, buf); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	st.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = st.Read(buf); err == nil || err == io.EOF || err == ErrTimeout {
+		t.Fatalf("expected the error of the session, got %v", err)
+	}
+}
+
+// slowDialer blocks the dials after the first one until release is closed.
+type slowDialer struct {
+	pipeDialer
+	release chan struct{}
+}
+
+func (d *slowDialer) Dial(network, addr string) (net.Conn, error) {
+	if d.dials > 0 {
+		<-d.release
+	}
+	return d.pipeDialer.Dial(network, addr)
+}
+
+// TestPoolSlowDial checks that the open session is used while another one is
+// being dialed.
+func TestPoolSlowDial(t *testing.T) {
+	echo := echoServer(t)
+	defer echo.Close()
+
+	d := &slowDialer{release: make(chan struct{})}
+	p := NewPool(d, nil)
+	p.MaxStreams = 1
+	defer p.Close()
+
+	first, err := p.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// the first session is full, so the second stream dials another one
+	second := make(chan error, 1)
+	go func() {
+		c, err := p.Dial("tcp", echo.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+		second <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		c, err := p.Dial("tcp", echo.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked behind the dial of a session")
+	}
+
+	close(d.release)
+	if err := <-second; err != nil {
+		t.Fatal(err)
+	}
+	if n := p.NumSessions(); n != 2 {
+		t.Errorf("expect 2 sessions, got %d", n)
+	}
+}
@@ -0,0 +1,177 @@
+package mux
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// Addr is the destination dialed through the underlying dialer to open a mux
+// session. A server that supports mux serves the connection with Serve instead
+// of connecting to it. The reserved .invalid TLD makes servers without mux
+// support fail fast.
+const Addr = "mux.shadowsocksr.invalid:1"
+
+const (
+	// DefaultMaxSessions is the number of long-lived connections of a Pool.
+	DefaultMaxSessions = 4
+	// DefaultMaxStreams is the number of streams carried by a session before
+	// the pool opens another one.
+	DefaultMaxStreams = 128
+)
+
+// Pool is a dialer that opens logical streams over a few long-lived
+// connections of an underlying dialer such as client.SSR.
+type Pool struct {
+	log    *logrus.Logger
+	dialer proxy.Dialer
+
+	// MaxSessions is the number of connections opened before streams share
+	// them beyond MaxStreams, DefaultMaxSessions if zero.
+	MaxSessions int
+	// MaxStreams is the number of streams per connection before another one
+	// is opened, DefaultMaxStreams if zero.
+	MaxStreams int
+
+	mu       sync.Mutex
+	sessions []*Session
+	// opening is closed once the session being dialed is open, nil if none
+	opening chan struct{}
+}
+
+// NewPool returns a mux pool over d.
+func NewPool(d proxy.Dialer, log *logrus.Logger) *Pool {
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &Pool{
+		log:    log,
+		dialer: d,
+	}
+}
+
+// Dial opens a stream to the address addr on the network net.
+func (p *Pool) Dial(network, addr string) (net.Conn, error) {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, errors.New("[mux] unsupported network: " + network)
+	}
+	target := socks.ParseAddr(addr)
+	if target == nil {
+		return nil, errors.New("[mux] unable to parse address: " + addr)
+	}
+	sess, err := p.session()
+	if err != nil {
+		return nil, err
+	}
+	st, err := sess.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	// the destination leads the stream, as it does in an SSR connection
+	if _, err = st.Write(target); err != nil {
+		st.Close()
+		return nil, err
+	}
+	p.log.Printf("[mux] stream %d %v <-> %v\n", st.ID(), sess.RemoteAddr(), target)
+	return st, nil
+}
+
+// NumSessions returns the number of open connections of the pool.
+func (p *Pool) NumSessions() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune()
+	return len(p.sessions)
+}
+
+// Close closes every connection of the pool and their streams.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range p.sessions {
+		s.Close()
+	}
+	p.sessions = nil
+	return nil
+}
+
+// session returns the least loaded session, opening a new one if every
+// session carries MaxStreams streams and there are less than MaxSessions.
+// The new session is dialed without holding p.mu, so that a slow server does
+// not hold up the streams of the open sessions: while it is dialed, the other
+// callers share the open sessions, or wait for it if there is none.
+func (p *Pool) session() (*Session, error) {
+	maxSessions, maxStreams := p.MaxSessions, p.MaxStreams
+	if maxSessions <= 0 {
+		maxSessions = DefaultMaxSessions
+	}
+	if maxStreams <= 0 {
+		maxStreams = DefaultMaxStreams
+	}
+
+	p.mu.Lock()
+	for {
+		best := p.leastLoaded()
+		if best != nil && (best.NumStreams() < maxStreams || len(p.sessions) >= maxSessions || p.opening != nil) {
+			p.mu.Unlock()
+			return best, nil
+		}
+		if p.opening == nil {
+			break
+		}
+		opening := p.opening
+		p.mu.Unlock()
+		<-opening
+		p.mu.Lock()
+	}
+	opening := make(chan struct{})
+	p.opening = opening
+	p.mu.Unlock()
+
+	c, err := p.dialer.Dial("tcp", Addr)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opening = nil
+	close(opening)
+	best := p.leastLoaded()
+	if err != nil {
+		if best != nil {
+			p.log.Warnf("[mux] failed to open a session, reusing one: %v", err)
+			return best, nil
+		}
+		return nil, err
+	}
+	s := Client(c)
+	p.sessions = append(p.sessions, s)
+	return s, nil
+}
+
+// leastLoaded prunes the closed sessions and returns the one with the fewest
+// streams, nil if there is none. p.mu must be held.
+func (p *Pool) leastLoaded() *Session {
+	p.prune()
+	var best *Session
+	for _, s := range p.sessions {
+		if best == nil || s.NumStreams() < best.NumStreams() {
+			best = s
+		}
+	}
+	return best
+}
+
+// prune removes closed sessions, p.mu must be held.
+func (p *Pool) prune() {
+	sessions := p.sessions[:0]
+	for _, s := range p.sessions {
+		if !s.IsClosed() {
+			sessions = append(sessions, s)
+		}
+	}
+	p.sessions = sessions
+}
@@ -0,0 +1,45 @@
+package mux
+
+import (
+	"net"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// Serve demultiplexes the streams of conn, which was opened by a Pool. Every
+// stream is connected to its destination with d. Serve returns when the
+// session is closed.
+func Serve(conn net.Conn, d proxy.Dialer, log *logrus.Logger) error {
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	sess := Server(conn)
+	defer sess.Close()
+	for {
+		st, err := sess.AcceptStream()
+		if err != nil {
+			return err
+		}
+		go serveStream(st, d, log)
+	}
+}
+
+func serveStream(st *Stream, d proxy.Dialer, log *logrus.Logger) {
+	target, err := socks.ReadAddr(st)
+	if err != nil {
+		log.Warnf("[mux] stream %d: read destination: %v", st.ID(), err)
+		st.Close()
+		return
+	}
+	rc, err := d.Dial("tcp", target.String())
+	if err != nil {
+		log.Warnf("[mux] stream %d: failed to connect to %v: %v", st.ID(), target, err)
+		st.Close()
+		return
+	}
+	log.Infof("[mux] stream %d %v <-> %v", st.ID(), st.RemoteAddr(), target)
+	tools.Relay(st, rc)
+}
@@ -0,0 +1,279 @@
+package mux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionClosed is returned when using a closed session or a stream of it.
+	ErrSessionClosed = errors.New("mux: session closed")
+	// ErrTimeout is returned when a stream deadline is exceeded.
+	ErrTimeout = &timeoutError{}
+)
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "mux: i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+const (
+	// DefaultKeepAliveInterval is the period between two keepalive frames.
+	DefaultKeepAliveInterval = 10 * time.Second
+	// DefaultKeepAliveTimeout is how long a session is kept without receiving anything.
+	DefaultKeepAliveTimeout = 30 * time.Second
+	// DefaultReceiveBuffer is the number of received bytes a session buffers
+	// for its streams before it stops reading from the connection.
+	DefaultReceiveBuffer = 4 * 1024 * 1024
+)
+
+// Session multiplexes streams over a connection. Streams opened by the client
+// side have odd ids, those opened by the server side have even ids.
+type Session struct {
+	conn net.Conn
+
+	nextID uint32
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+
+	// bucket is the remaining receive buffer shared by the streams
+	bucket     int32
+	bucketCond *sync.Cond
+
+	writeMu sync.Mutex
+
+	acceptCh chan *Stream
+
+	dataReady int32
+	die       chan struct{}
+	dieOnce   sync.Once
+	err       error
+}
+
+// Client returns the client side of a session over conn.
+func Client(conn net.Conn) *Session {
+	return newSession(conn, true)
+}
+
+// Server returns the server side of a session over conn.
+func Server(conn net.Conn) *Session {
+	return newSession(conn, false)
+}
+
+func newSession(conn net.Conn, isClient bool) *Session {
+	s := &Session{
+		conn:       conn,
+		streams:    make(map[uint32]*Stream),
+		bucket:     DefaultReceiveBuffer,
+		bucketCond: sync.NewCond(&sync.Mutex{}),
+		acceptCh:   make(chan *Stream, 1024),
+		die:        make(chan struct{}),
+	}
+	if isClient {
+		s.nextID = 1
+	} else {
+		s.nextID = 0
+	}
+	go s.recvLoop()
+	go s.keepAlive()
+	return s
+}
+
+// OpenStream opens a new stream.
+func (s *Session) OpenStream() (*Stream, error) {
+	if s.IsClosed() {
+		return nil, ErrSessionClosed
+	}
+	id := atomic.AddUint32(&s.nextID, 2)
+	st := newStream(id, s)
+	s.mu.Lock()
+	s.streams[id] = st
+	s.mu.Unlock()
+	if err := s.writeFrame(newFrame(cmdSYN, id, nil)); err != nil {
+		s.mu.Lock()
+		delete(s.streams, id)
+		s.mu.Unlock()
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for the peer to open a stream.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.acceptCh:
+		return st, nil
+	case <-s.die:
+		return nil, s.closeErr()
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed reports whether the session is closed.
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.die:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the session, its streams and the underlying connection.
+func (s *Session) Close() error {
+	s.closeWithError(ErrSessionClosed)
+	return nil
+}
+
+// LocalAddr returns the local address of the underlying connection.
+func (s *Session) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the underlying connection.
+func (s *Session) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+func (s *Session) closeWithError(err error) {
+	s.dieOnce.Do(func() {
+		s.err = err
+		close(s.die)
+		s.conn.Close()
+		s.bucketCond.L.Lock()
+		s.bucketCond.Broadcast()
+		s.bucketCond.L.Unlock()
+	})
+}
+
+func (s *Session) closeErr() error {
+	if s.err != nil {
+		return s.err
+	}
+	return ErrSessionClosed
+}
+
+func (s *Session) recvLoop() {
+	var h header
+	for {
+		// wait until the streams have consumed some of the received data
+		s.bucketCond.L.Lock()
+		for atomic.LoadInt32(&s.bucket) <= 0 && !s.IsClosed() {
+			s.bucketCond.Wait()
+		}
+		s.bucketCond.L.Unlock()
+
+		if _, err := io.ReadFull(s.conn, h[:]); err != nil {
+			s.closeWithError(err)
+			return
+		}
+		atomic.StoreInt32(&s.dataReady, 1)
+		if h.version() != version {
+			s.closeWithError(fmt.Errorf("mux: invalid frame: %v", h))
+			return
+		}
+		id := h.streamID()
+		switch h.cmd() {
+		case cmdNOP:
+		case cmdSYN:
+			s.mu.Lock()
+			_, ok := s.streams[id]
+			var st *Stream
+			if !ok {
+				st = newStream(id, s)
+				s.streams[id] = st
+			}
+			s.mu.Unlock()
+			if st != nil {
+				select {
+				case s.acceptCh <- st:
+				case <-s.die:
+				}
+			}
+		case cmdFIN:
+			s.mu.Lock()
+			st, ok := s.streams[id]
+			s.mu.Unlock()
+			if ok {
+				st.remoteClose()
+			}
+		case cmdPSH:
+			data := make([]byte, h.length())
+			if _, err := io.ReadFull(s.conn, data); err != nil {
+				s.closeWithError(err)
+				return
+			}
+			s.mu.Lock()
+			st, ok := s.streams[id]
+			s.mu.Unlock()
+			if ok && len(data) > 0 {
+				atomic.AddInt32(&s.bucket, -int32(len(data)))
+				st.pushBytes(data)
+			}
+		default:
+			s.closeWithError(fmt.Errorf("mux: invalid frame: %v", h))
+			return
+		}
+	}
+}
+
+func (s *Session) keepAlive() {
+	ping := time.NewTicker(DefaultKeepAliveInterval)
+	defer ping.Stop()
+	timeout := time.NewTicker(DefaultKeepAliveTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ping.C:
+			_ = s.writeFrame(newFrame(cmdNOP, 0, nil))
+		case <-timeout.C:
+			if !atomic.CompareAndSwapInt32(&s.dataReady, 1, 0) {
+				s.closeWithError(errors.New("mux: keepalive timeout"))
+				return
+			}
+		case <-s.die:
+			return
+		}
+	}
+}
+
+// returnTokens gives back n bytes of receive buffer consumed by a stream.
+func (s *Session) returnTokens(n int) {
+	if atomic.AddInt32(&s.bucket, int32(n)) > 0 {
+		s.bucketCond.L.Lock()
+		s.bucketCond.Signal()
+		s.bucketCond.L.Unlock()
+	}
+}
+
+func (s *Session) streamClosed(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+func (s *Session) writeFrame(frame []byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.IsClosed() {
+		return s.closeErr()
+	}
+	if _, err := s.conn.Write(frame); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
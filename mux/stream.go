@@ -0,0 +1,227 @@
+package mux
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a logical connection of a Session. It implements net.Conn.
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	mu        sync.Mutex
+	buffer    bytes.Buffer
+	readReady chan struct{}
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	finRecv   bool // the peer will not send anymore
+	finSent   bool // we will not send anymore
+	closed    bool // Close has been called
+	closeOnce sync.Once
+}
+
+func newStream(id uint32, sess *Session) *Stream {
+	return &Stream{
+		id:        id,
+		sess:      sess,
+		readReady: make(chan struct{}, 1),
+	}
+}
+
+// ID returns the id of the stream.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read implements net.Conn.
+func (st *Stream) Read(b []byte) (n int, err error) {
+	for {
+		st.mu.Lock()
+		if st.closed {
+			st.mu.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if st.buffer.Len() > 0 {
+			n, _ = st.buffer.Read(b)
+			st.mu.Unlock()
+			st.sess.returnTokens(n)
+			return n, nil
+		}
+		finRecv := st.finRecv
+		deadline := st.readDeadline
+		st.mu.Unlock()
+		if finRecv {
+			return 0, io.EOF
+		}
+
+		if err = st.waitRead(deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// waitRead waits until data or EOF may be available. It returns the error of
+// the session if it dies before the peer closed the stream.
+func (st *Stream) waitRead(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return ErrTimeout
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-st.readReady:
+	case <-timeout:
+		return ErrTimeout
+	case <-st.sess.die:
+		st.mu.Lock()
+		buffered, finRecv := st.buffer.Len(), st.finRecv
+		st.mu.Unlock()
+		// without FIN from the peer, the stream was cut short
+		if buffered == 0 && !finRecv {
+			err := st.sess.closeErr()
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements net.Conn.
+func (st *Stream) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		st.mu.Lock()
+		finSent := st.finSent
+		deadline := st.writeDeadline
+		st.mu.Unlock()
+		if finSent {
+			return n, io.ErrClosedPipe
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return n, ErrTimeout
+		}
+		size := len(b)
+		if size > maxPayload {
+			size = maxPayload
+		}
+		if err = st.sess.writeFrame(newFrame(cmdPSH, st.id, b[:size])); err != nil {
+			return n, err
+		}
+		n += size
+		b = b[size:]
+	}
+	return n, nil
+}
+
+// CloseWrite sends EOF to the peer, which can still send data.
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.finSent {
+		st.mu.Unlock()
+		return nil
+	}
+	st.finSent = true
+	finished := st.finRecv
+	st.mu.Unlock()
+	err := st.sess.writeFrame(newFrame(cmdFIN, st.id, nil))
+	if finished {
+		st.sess.streamClosed(st.id)
+	}
+	return err
+}
+
+// Close closes both directions of the stream.
+func (st *Stream) Close() error {
+	var err error
+	st.closeOnce.Do(func() {
+		err = st.CloseWrite()
+		st.mu.Lock()
+		st.closed = true
+		n := st.buffer.Len()
+		st.buffer.Reset()
+		st.mu.Unlock()
+		st.sess.returnTokens(n)
+		st.sess.streamClosed(st.id)
+		st.notifyReadEvent()
+	})
+	return err
+}
+
+// LocalAddr implements net.Conn.
+func (st *Stream) LocalAddr() net.Addr {
+	return st.sess.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (st *Stream) RemoteAddr() net.Addr {
+	return st.sess.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.SetReadDeadline(t)
+	st.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.mu.Unlock()
+	st.notifyReadEvent()
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn. Writes only check the deadline
+// before each frame, as frames of all streams share the session.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.mu.Unlock()
+	return nil
+}
+
+// pushBytes appends data received from the peer.
+func (st *Stream) pushBytes(data []byte) {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		st.sess.returnTokens(len(data))
+		return
+	}
+	st.buffer.Write(data)
+	st.mu.Unlock()
+	st.notifyReadEvent()
+}
+
+// remoteClose marks that the peer has sent EOF.
+func (st *Stream) remoteClose() {
+	st.mu.Lock()
+	st.finRecv = true
+	finished := st.finSent
+	st.mu.Unlock()
+	if finished {
+		st.sess.streamClosed(st.id)
+	}
+	st.notifyReadEvent()
+}
+
+func (st *Stream) notifyReadEvent() {
+	select {
+	case st.readReady <- struct{}{}:
+	default:
+	}
+}
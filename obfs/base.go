@@ -1,6 +1,7 @@
 package obfs
 
 import (
+	"sort"
 	"strings"
 
 	"github.com/v2rayA/shadowsocksR/ssr"
@@ -22,15 +23,42 @@ type IObfs interface {
 	GetOverhead() int
 }
 
+// IHandshaker is implemented by obfs whose handshake does not depend on the
+// payload, so that it can be completed before the first write.
+type IHandshaker interface {
+	// HandshakeDone reports whether the obfs handshake has completed.
+	HandshakeDone() bool
+}
+
+// IFlusher is implemented by obfs that hold back the data written before their
+// handshake completes.
+type IFlusher interface {
+	// Flush returns the encoded data held back, which must be sent before the
+	// writing side of the connection is closed.
+	Flush() ([]byte, error)
+}
+
 func register(name string, c creator) {
 	creatorMap[name] = c
 }
 
-// NewObfs create an obfs object by name and return as an IObfs interface
+// NewObfs create an obfs object by name and return as an IObfs interface.
+// The "_compatible" suffix of a name, which lets a server accept plain clients
+// too, is ignored.
 func NewObfs(name string) IObfs {
-	c, ok := creatorMap[strings.ToLower(name)]
+	c, ok := creatorMap[strings.TrimSuffix(strings.ToLower(name), "_compatible")]
 	if ok {
 		return c()
 	}
 	return nil
 }
+
+// Names returns the sorted names of the registered obfs.
+func Names() []string {
+	names := make([]string, 0, len(creatorMap))
+	for name := range creatorMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -69,6 +69,17 @@ func (r *randomHead) Encode(data []byte) (encodedData []byte, err error) {
 	return
 }
 
+func (r *randomHead) HandshakeDone() bool {
+	return r.rawTransSent
+}
+
+func (r *randomHead) Flush() ([]byte, error) {
+	if !r.hasSentHeader || r.rawTransSent {
+		return nil, nil
+	}
+	return r.Encode(nil)
+}
+
 func (r *randomHead) Decode(data []byte) (decodedData []byte, needSendBack bool, err error) {
 	if r.rawTransReceived {
 		return data, false, nil
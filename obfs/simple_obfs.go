@@ -0,0 +1,286 @@
+package obfs
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+func init() {
+	register("simple_obfs_http", newSimpleObfsHTTP)
+	register("simple_obfs_tls", newSimpleObfsTLS)
+}
+
+const (
+	// simpleObfsMaxHeader bounds the HTTP response header of the server.
+	simpleObfsMaxHeader = 8192
+	// simpleObfsMaxTicket is the most payload sent in the session ticket of
+	// the ClientHello, the rest follows as application data.
+	simpleObfsMaxTicket = 8192
+	// simpleObfsMaxRecord is the largest TLS record payload.
+	simpleObfsMaxRecord = 16384
+)
+
+var (
+	simpleObfsCipherSuites = []byte{
+		0xc0, 0x2c, 0xc0, 0x30, 0x00, 0x9f, 0xcc, 0xa9, 0xcc, 0xa8, 0xcc, 0xaa, 0xc0, 0x2b, 0xc0, 0x2f,
+		0x00, 0x9e, 0xc0, 0x24, 0xc0, 0x28, 0x00, 0x6b, 0xc0, 0x23, 0xc0, 0x27, 0x00, 0x67, 0xc0, 0x0a,
+		0xc0, 0x14, 0x00, 0x39, 0xc0, 0x09, 0xc0, 0x13, 0x00, 0x33, 0x00, 0x9d, 0x00, 0x9c, 0x00, 0x3d,
+		0x00, 0x3c, 0x00, 0x35, 0x00, 0x2f, 0x00, 0xff,
+	}
+	// ec_point_formats, supported_groups, signature_algorithms,
+	// encrypt_then_mac and extended_master_secret extensions
+	simpleObfsOtherExtensions = []byte{
+		0x00, 0x0b, 0x00, 0x04, 0x03, 0x01, 0x00, 0x02,
+		0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x19, 0x00, 0x18,
+		0x00, 0x0d, 0x00, 0x20, 0x00, 0x1e,
+		0x06, 0x01, 0x06, 0x02, 0x06, 0x03, 0x05, 0x01, 0x05, 0x02, 0x05, 0x03, 0x04, 0x01, 0x04, 0x02,
+		0x04, 0x03, 0x03, 0x01, 0x03, 0x02, 0x03, 0x03, 0x02, 0x01, 0x02, 0x02, 0x02, 0x03,
+		0x00, 0x16, 0x00, 0x00,
+		0x00, 0x17, 0x00, 0x00,
+	}
+)
+
+// simpleObfsParam returns the host and the request URI of the obfs param,
+// either a host or simple-obfs options such as obfs-host=example.com;obfs-uri=/.
+// The host defaults to the server address.
+func simpleObfsParam(s *ssr.ServerInfo) (host, uri string) {
+	host, uri = s.Host, "/"
+	if !strings.Contains(s.Param, "=") {
+		if p := strings.TrimSpace(s.Param); p != "" {
+			host = p
+		}
+		return
+	}
+	for _, opt := range strings.Split(s.Param, ";") {
+		kv := strings.SplitN(strings.TrimSpace(opt), "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			continue
+		}
+		switch kv[0] {
+		case "obfs-host":
+			host = kv[1]
+		case "obfs-uri":
+			uri = kv[1]
+		}
+	}
+	return
+}
+
+// simpleObfsHTTP is the http mode of simple-obfs: the first request is an
+// HTTP websocket upgrade carrying the payload, and the rest of the connection
+// is raw.
+type simpleObfsHTTP struct {
+	ssr.ServerInfo
+	headerSent     bool
+	headerReceived bool
+	recvBuffer     bytes.Buffer
+}
+
+func newSimpleObfsHTTP() IObfs {
+	return &simpleObfsHTTP{}
+}
+
+func (t *simpleObfsHTTP) SetServerInfo(s *ssr.ServerInfo) {
+	t.ServerInfo = *s
+}
+
+func (t *simpleObfsHTTP) GetServerInfo() (s *ssr.ServerInfo) {
+	return &t.ServerInfo
+}
+
+func (t *simpleObfsHTTP) SetData(data interface{}) {
+
+}
+
+func (t *simpleObfsHTTP) GetData() interface{} {
+	return nil
+}
+
+func (t *simpleObfsHTTP) Encode(data []byte) (encodedData []byte, err error) {
+	if t.headerSent {
+		return data, nil
+	}
+	t.headerSent = true
+	host, uri := simpleObfsParam(&t.ServerInfo)
+	if t.Port != 80 {
+		host += ":" + strconv.Itoa(int(t.Port))
+	}
+	key := make([]byte, 16)
+	rand.Read(key)
+	header := fmt.Sprintf("GET %s HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"User-Agent: curl/7.%d.%d\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Content-Length: %d\r\n\r\n",
+		uri, host, rand.Intn(51), rand.Intn(2), base64.StdEncoding.EncodeToString(key), len(data))
+	encodedData = make([]byte, len(header)+len(data))
+	copy(encodedData, header)
+	copy(encodedData[len(header):], data)
+	return encodedData, nil
+}
+
+func (t *simpleObfsHTTP) Decode(data []byte) (decodedData []byte, needSendBack bool, err error) {
+	if t.headerReceived {
+		return data, false, nil
+	}
+	t.recvBuffer.Write(data)
+	buf := t.recvBuffer.Bytes()
+	if len(buf) >= 5 && !bytes.HasPrefix(buf, []byte("HTTP/")) {
+		return nil, false, fmt.Errorf("%w: %q", ssr.ErrSimpleObfsHTTPIncorrectResponse, buf[:5])
+	}
+	i := bytes.Index(buf, []byte("\r\n\r\n"))
+	if i < 0 {
+		if len(buf) > simpleObfsMaxHeader {
+			return nil, false, fmt.Errorf("%w: header too long", ssr.ErrSimpleObfsHTTPIncorrectResponse)
+		}
+		return nil, false, nil
+	}
+	t.headerReceived = true
+	decodedData = append([]byte(nil), buf[i+4:]...)
+	t.recvBuffer.Reset()
+	return decodedData, false, nil
+}
+
+func (t *simpleObfsHTTP) GetOverhead() int {
+	return 0
+}
+
+// simpleObfsTLS is the tls mode of simple-obfs: the first payload is sent as
+// the session ticket of a ClientHello and the rest as TLS application data.
+// Unlike tls1.2_ticket_auth, nothing is authenticated and the client does not
+// wait for the server hello.
+type simpleObfsTLS struct {
+	ssr.ServerInfo
+	helloSent  bool
+	recvBuffer bytes.Buffer
+	buffer     bytes.Buffer
+}
+
+func newSimpleObfsTLS() IObfs {
+	return &simpleObfsTLS{}
+}
+
+func (t *simpleObfsTLS) SetServerInfo(s *ssr.ServerInfo) {
+	t.ServerInfo = *s
+}
+
+func (t *simpleObfsTLS) GetServerInfo() (s *ssr.ServerInfo) {
+	return &t.ServerInfo
+}
+
+func (t *simpleObfsTLS) SetData(data interface{}) {
+
+}
+
+func (t *simpleObfsTLS) GetData() interface{} {
+	return nil
+}
+
+func (t *simpleObfsTLS) Encode(data []byte) (encodedData []byte, err error) {
+	t.buffer.Reset()
+	if !t.helloSent {
+		t.helloSent = true
+		ticket := data
+		if len(ticket) > simpleObfsMaxTicket {
+			ticket = ticket[:simpleObfsMaxTicket]
+		}
+		host, _ := simpleObfsParam(&t.ServerInfo)
+		t.writeClientHello(ticket, host)
+		data = data[len(ticket):]
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > simpleObfsMaxRecord {
+			n = simpleObfsMaxRecord
+		}
+		packData(&t.buffer, data[:n])
+		data = data[n:]
+	}
+	return t.buffer.Bytes(), nil
+}
+
+// writeClientHello writes a TLS record of a ClientHello to t.buffer.
+func (t *simpleObfsTLS) writeClientHello(ticket []byte, host string) {
+	var ext bytes.Buffer
+	// session ticket
+	writeUint16(&ext, 0x0023)
+	writeUint16(&ext, len(ticket))
+	ext.Write(ticket)
+	// server name
+	writeUint16(&ext, 0x0000)
+	writeUint16(&ext, len(host)+5)
+	writeUint16(&ext, len(host)+3)
+	ext.WriteByte(0)
+	writeUint16(&ext, len(host))
+	ext.WriteString(host)
+	ext.Write(simpleObfsOtherExtensions)
+
+	var hello bytes.Buffer
+	hello.Write([]byte{0x03, 0x03})
+	random := make([]byte, 32)
+	binary.BigEndian.PutUint32(random, uint32(time.Now().Unix()))
+	rand.Read(random[4:])
+	hello.Write(random)
+	sessionID := make([]byte, 32)
+	rand.Read(sessionID)
+	hello.WriteByte(byte(len(sessionID)))
+	hello.Write(sessionID)
+	writeUint16(&hello, len(simpleObfsCipherSuites))
+	hello.Write(simpleObfsCipherSuites)
+	// null compression
+	hello.Write([]byte{0x01, 0x00})
+	writeUint16(&hello, ext.Len())
+	hello.Write(ext.Bytes())
+
+	// record header, then handshake header of a ClientHello
+	t.buffer.Write([]byte{0x16, 0x03, 0x01})
+	writeUint16(&t.buffer, hello.Len()+4)
+	t.buffer.Write([]byte{0x01, byte(hello.Len() >> 16), byte(hello.Len() >> 8), byte(hello.Len())})
+	t.buffer.Write(hello.Bytes())
+}
+
+// Decode returns the application data of the TLS records of the server,
+// skipping its handshake and change cipher spec records.
+func (t *simpleObfsTLS) Decode(data []byte) (decodedData []byte, needSendBack bool, err error) {
+	t.recvBuffer.Write(data)
+	for t.recvBuffer.Len() >= 5 {
+		h := t.recvBuffer.Bytes()[:5]
+		if h[1] != 0x03 || h[2] > 0x03 {
+			return nil, false, fmt.Errorf("%w: version %#x%02x", ssr.ErrSimpleObfsTLSIncorrectRecord, h[1], h[2])
+		}
+		size := int(binary.BigEndian.Uint16(h[3:5]))
+		if t.recvBuffer.Len() < 5+size {
+			// read it next time
+			break
+		}
+		typ := h[0]
+		t.recvBuffer.Next(5)
+		payload := t.recvBuffer.Next(size)
+		switch typ {
+		case 0x17:
+			decodedData = append(decodedData, payload...)
+		case 0x14, 0x16:
+		default:
+			return nil, false, fmt.Errorf("%w: type %#x", ssr.ErrSimpleObfsTLSIncorrectRecord, typ)
+		}
+	}
+	return decodedData, false, nil
+}
+
+func (t *simpleObfsTLS) GetOverhead() int {
+	return 5
+}
+
+func writeUint16(b *bytes.Buffer, v int) {
+	b.Write([]byte{byte(v >> 8), byte(v)})
+}
@@ -0,0 +1,87 @@
+package obfs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+func TestSimpleObfsHTTP(t *testing.T) {
+	o := NewObfs("simple_obfs_http")
+	o.SetServerInfo(&ssr.ServerInfo{Host: "1.2.3.4", Port: 8388, Param: "obfs-host=example.com;obfs-uri=/chat"})
+	payload := []byte("payload")
+	out, err := o.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Host != "example.com:8388" || req.URL.Path != "/chat" || req.Header.Get("Upgrade") != "websocket" || req.ContentLength != int64(len(payload)) {
+		t.Fatalf("unexpected request: %+v", req)
+	}
+	if !bytes.HasSuffix(out, payload) {
+		t.Fatal("payload not sent after the header")
+	}
+	if out, _ = o.Encode(payload); !bytes.Equal(out, payload) {
+		t.Fatalf("later data should be raw, got %q", out)
+	}
+
+	// the response header may be split
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n"
+	if d, _, err := o.Decode([]byte(response[:20])); err != nil || len(d) != 0 {
+		t.Fatalf("got %q, %v", d, err)
+	}
+	if d, _, err := o.Decode([]byte(response[20:] + "data")); err != nil || string(d) != "data" {
+		t.Fatalf("got %q, %v", d, err)
+	}
+	if d, _, err := o.Decode([]byte("more")); err != nil || string(d) != "more" {
+		t.Fatalf("got %q, %v", d, err)
+	}
+}
+
+func TestSimpleObfsTLS(t *testing.T) {
+	o := NewObfs("simple_obfs_tls")
+	o.SetServerInfo(&ssr.ServerInfo{Host: "1.2.3.4", Port: 443, Param: "example.com"})
+	payload := bytes.Repeat([]byte{0x42}, simpleObfsMaxTicket+10)
+	out, err := o.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a ClientHello record carrying the ticket, then application data
+	if out[0] != 0x16 || out[5] != 0x01 {
+		t.Fatalf("not a ClientHello record: % x", out[:6])
+	}
+	helloLen := int(binary.BigEndian.Uint16(out[3:5]))
+	if int(out[6])<<16|int(out[7])<<8|int(out[8]) != helloLen-4 {
+		t.Fatal("handshake length does not match the record length")
+	}
+	hello := out[5 : 5+helloLen]
+	if !bytes.Contains(hello, payload[:simpleObfsMaxTicket]) || !bytes.Contains(hello, []byte("example.com")) {
+		t.Fatal("ticket or server name missing from the ClientHello")
+	}
+	rest := out[5+helloLen:]
+	if !bytes.Equal(rest, append([]byte{0x17, 0x03, 0x03, 0x00, 0x0a}, payload[simpleObfsMaxTicket:]...)) {
+		t.Fatalf("unexpected application data: % x", rest)
+	}
+
+	// server hello, change cipher spec and finished are skipped, and the
+	// application data may be split
+	response := []byte{0x16, 0x03, 0x03, 0x00, 0x02, 0xaa, 0xbb, 0x14, 0x03, 0x03, 0x00, 0x01, 0x01,
+		0x17, 0x03, 0x03, 0x00, 0x04, 'd', 'a', 't', 'a'}
+	if d, _, err := o.Decode(response[:15]); err != nil || len(d) != 0 {
+		t.Fatalf("got %q, %v", d, err)
+	}
+	if d, _, err := o.Decode(response[15:]); err != nil || string(d) != "data" {
+		t.Fatalf("got %q, %v", d, err)
+	}
+	if _, _, err := o.Decode([]byte("HTTP/1.1 400")); err == nil {
+		t.Fatal("expected an error for a non TLS response")
+	}
+}
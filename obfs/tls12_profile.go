@@ -0,0 +1,328 @@
+package obfs
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+// DefaultTLSProfile is the ClientHello profile of tls1.2_ticket_auth when the
+// obfs param does not select one.
+const DefaultTLSProfile = "legacy"
+
+// tlsProfile builds the cipher suites and the extensions of a ClientHello
+// carrying the SNI host, empty for none, and the session ticket.
+type tlsProfile struct {
+	hello func(host string, ticket []byte) (cipherSuites []uint16, extensions []tlsExtension, err error)
+	// padding pads the ClientHello to 512 bytes as BoringSSL and NSS do, to
+	// avoid the sizes some middleboxes choke on.
+	padding bool
+}
+
+var tlsProfiles = map[string]*tlsProfile{
+	"legacy":  {hello: legacyHello},
+	"chrome":  {hello: chromeHello, padding: true},
+	"firefox": {hello: firefoxHello, padding: true},
+}
+
+// TLSProfiles returns the sorted names of the ClientHello profiles of
+// tls1.2_ticket_auth.
+func TLSProfiles() []string {
+	names := make([]string, 0, len(tlsProfiles))
+	for name := range tlsProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SplitTLSProfile splits the obfs param of tls1.2_ticket_auth, comma
+// separated hosts optionally followed by # and the name of a ClientHello
+// profile, as in "example.com,example.org#chrome".
+func SplitTLSProfile(param string) (hosts, profile string) {
+	if i := strings.LastIndexByte(param, '#'); i >= 0 {
+		return param[:i], strings.TrimSpace(param[i+1:])
+	}
+	return param, ""
+}
+
+// tlsExtension is an extension of a ClientHello.
+type tlsExtension struct {
+	typ  uint16
+	data []byte
+}
+
+// TLS extension types
+const (
+	extServerName          = 0x0000
+	extStatusRequest       = 0x0005
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSCT                 = 0x0012
+	extPadding             = 0x0015
+	extExtendedMasterSec   = 0x0017
+	extCompressCertificate = 0x001b
+	extRecordSizeLimit     = 0x001c
+	extDelegatedCredential = 0x0022
+	extSessionTicket       = 0x0023
+	extSupportedVersions   = 0x002b
+	extPSKModes            = 0x002d
+	extKeyShare            = 0x0033
+	extApplicationSettings = 0x4469
+	extChannelID           = 0x7550
+	extRenegotiationInfo   = 0xff01
+)
+
+// legacyHello is the ClientHello tls1.2_ticket_auth has always sent, of a
+// browser of the TLS 1.2 era.
+func legacyHello(host string, ticket []byte) ([]uint16, []tlsExtension, error) {
+	cipherSuites := []uint16{
+		0xc02b, 0xc02f, 0xcca9, 0xcca8, 0xcc14, 0xcc13, 0xc00a, 0xc014,
+		0xc009, 0xc013, 0x009c, 0x0035, 0x002f, 0x000a,
+	}
+	return cipherSuites, []tlsExtension{
+		{extRenegotiationInfo, []byte{0}},
+		{extServerName, serverNameData(host)},
+		{extExtendedMasterSec, nil},
+		{extSessionTicket, ticket},
+		{extSignatureAlgorithms, uint16List(0x0601, 0x0603, 0x0501, 0x0503, 0x0401, 0x0403, 0x0301, 0x0303, 0x0201, 0x0203)},
+		{extStatusRequest, []byte{1, 0, 0, 0, 0}},
+		{extSCT, nil},
+		{extChannelID, nil},
+		{extECPointFormats, []byte{1, 0}},
+		{extSupportedGroups, uint16List(0x0017, 0x0018)},
+		{extPadding, make([]byte, 0x66)},
+	}, nil
+}
+
+// chromeHello imitates Chrome: GREASE values, TLS 1.3 with an X25519 key
+// share, and extensions in a random order between two GREASE extensions.
+func chromeHello(host string, ticket []byte) ([]uint16, []tlsExtension, error) {
+	grease, err := greaseValues(5)
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites := []uint16{
+		grease[0], 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030,
+		0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+	}
+	var keyShare bytes.Buffer
+	writeUint16(&keyShare, int(grease[1]))
+	writeUint16(&keyShare, 1)
+	keyShare.WriteByte(0)
+	if err = writeKeyShare(&keyShare, 0x001d); err != nil {
+		return nil, nil, err
+	}
+
+	extensions := []tlsExtension{
+		{extExtendedMasterSec, nil},
+		{extRenegotiationInfo, []byte{0}},
+		{extSupportedGroups, uint16List(grease[1], 0x001d, 0x0017, 0x0018)},
+		{extECPointFormats, []byte{1, 0}},
+		{extSessionTicket, ticket},
+		{extALPN, alpnData("h2", "http/1.1")},
+		{extStatusRequest, []byte{1, 0, 0, 0, 0}},
+		{extSignatureAlgorithms, uint16List(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601)},
+		{extSCT, nil},
+		{extKeyShare, vector16(keyShare.Bytes())},
+		{extPSKModes, []byte{1, 1}},
+		{extSupportedVersions, uint8List(grease[2], 0x0304, 0x0303)},
+		{extCompressCertificate, []byte{2, 0, 2}},
+		{extApplicationSettings, vector16(alpnData("h2")[2:])},
+	}
+	if host != "" {
+		extensions = append(extensions, tlsExtension{extServerName, serverNameData(host)})
+	}
+	rand.Shuffle(len(extensions), func(i, j int) {
+		extensions[i], extensions[j] = extensions[j], extensions[i]
+	})
+	extensions = append([]tlsExtension{{grease[3], nil}}, extensions...)
+	extensions = append(extensions, tlsExtension{grease[4], []byte{0}})
+	return cipherSuites, extensions, nil
+}
+
+// firefoxHello imitates Firefox: TLS 1.3 with X25519 and P-256 key shares,
+// and extensions in a fixed order.
+func firefoxHello(host string, ticket []byte) ([]uint16, []tlsExtension, error) {
+	cipherSuites := []uint16{
+		0x1301, 0x1303, 0x1302, 0xc02b, 0xc02f, 0xcca9, 0xcca8, 0xc02c,
+		0xc030, 0xc00a, 0xc009, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+	}
+	var keyShare bytes.Buffer
+	for _, group := range []uint16{0x001d, 0x0017} {
+		if err := writeKeyShare(&keyShare, group); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var extensions []tlsExtension
+	if host != "" {
+		extensions = append(extensions, tlsExtension{extServerName, serverNameData(host)})
+	}
+	return cipherSuites, append(extensions,
+		tlsExtension{extExtendedMasterSec, nil},
+		tlsExtension{extRenegotiationInfo, []byte{0}},
+		tlsExtension{extSupportedGroups, uint16List(0x001d, 0x0017, 0x0018, 0x0019, 0x0100, 0x0101)},
+		tlsExtension{extECPointFormats, []byte{1, 0}},
+		tlsExtension{extSessionTicket, ticket},
+		tlsExtension{extALPN, alpnData("h2", "http/1.1")},
+		tlsExtension{extStatusRequest, []byte{1, 0, 0, 0, 0}},
+		tlsExtension{extDelegatedCredential, uint16List(0x0403, 0x0503, 0x0603, 0x0203)},
+		tlsExtension{extKeyShare, vector16(keyShare.Bytes())},
+		tlsExtension{extSupportedVersions, uint8List(0x0304, 0x0303)},
+		tlsExtension{extSignatureAlgorithms, uint16List(0x0403, 0x0503, 0x0603, 0x0804, 0x0805, 0x0806, 0x0401, 0x0501, 0x0601, 0x0203, 0x0201)},
+		tlsExtension{extPSKModes, []byte{1, 1}},
+		tlsExtension{extRecordSizeLimit, []byte{0x40, 0x01}},
+	), nil
+}
+
+// writeClientHello writes the TLS record of a ClientHello of profile p to b,
+// with the auth data as random and the client ID as session ID.
+func (p *tlsProfile) writeClientHello(b *bytes.Buffer, random, sessionID []byte, host string, ticket []byte) error {
+	cipherSuites, extensions, err := p.hello(host, ticket)
+	if err != nil {
+		return err
+	}
+
+	var hello bytes.Buffer
+	hello.Write([]byte{0x03, 0x03})
+	hello.Write(random)
+	hello.WriteByte(byte(len(sessionID)))
+	hello.Write(sessionID)
+	hello.Write(uint16List(cipherSuites...))
+	// null compression
+	hello.Write([]byte{0x01, 0x00})
+
+	var ext bytes.Buffer
+	for _, e := range extensions {
+		writeUint16(&ext, int(e.typ))
+		writeUint16(&ext, len(e.data))
+		ext.Write(e.data)
+	}
+	if p.padding {
+		// the length of the handshake message, without the padding
+		if n := 4 + hello.Len() + 2 + ext.Len(); n > 0xff && n < 0x200 {
+			padding := 0x200 - n
+			if padding >= 5 {
+				padding -= 4
+			} else {
+				padding = 1
+			}
+			writeUint16(&ext, extPadding)
+			writeUint16(&ext, padding)
+			ext.Write(make([]byte, padding))
+		}
+	}
+	writeUint16(&hello, ext.Len())
+	hello.Write(ext.Bytes())
+
+	// record header, then handshake header of a ClientHello
+	b.Write([]byte{0x16, 0x03, 0x01})
+	writeUint16(b, hello.Len()+4)
+	b.Write([]byte{0x01, byte(hello.Len() >> 16), byte(hello.Len() >> 8), byte(hello.Len())})
+	b.Write(hello.Bytes())
+	return nil
+}
+
+// greaseValues returns n distinct GREASE values, as defined by RFC 8701.
+func greaseValues(n int) ([]uint16, error) {
+	var r [16]byte
+	if _, err := crand.Read(r[:]); err != nil {
+		return nil, err
+	}
+	var perm [16]uint16
+	for i := range perm {
+		perm[i] = uint16(i)
+	}
+	for i := len(perm) - 1; i > 0; i-- {
+		j := int(r[i]) % (i + 1)
+		perm[i], perm[j] = perm[j], perm[i]
+	}
+	v := make([]uint16, n)
+	for i, p := range perm[:n] {
+		v[i] = p<<12 | 0x0a00 | p<<4 | 0x0a
+	}
+	return v, nil
+}
+
+// writeKeyShare writes a key share entry of group, X25519 or P-256, with a
+// fresh public key.
+func writeKeyShare(b *bytes.Buffer, group uint16) error {
+	var key []byte
+	switch group {
+	case 0x001d:
+		key = make([]byte, 32)
+		if _, err := crand.Read(key); err != nil {
+			return err
+		}
+	case 0x0017:
+		priv := make([]byte, 32)
+		if _, err := crand.Read(priv); err != nil {
+			return err
+		}
+		x, y := elliptic.P256().ScalarBaseMult(priv)
+		key = elliptic.Marshal(elliptic.P256(), x, y)
+	default:
+		return &ssr.UnsupportedError{Kind: "key share group", Name: fmt.Sprintf("%#04x", group)}
+	}
+	writeUint16(b, int(group))
+	writeUint16(b, len(key))
+	b.Write(key)
+	return nil
+}
+
+// serverNameData returns the data of a server_name extension of host.
+func serverNameData(host string) []byte {
+	var b bytes.Buffer
+	writeUint16(&b, len(host)+3)
+	b.WriteByte(0)
+	writeUint16(&b, len(host))
+	b.WriteString(host)
+	return b.Bytes()
+}
+
+// alpnData returns the data of an ALPN extension of protos.
+func alpnData(protos ...string) []byte {
+	var b bytes.Buffer
+	for _, p := range protos {
+		b.WriteByte(byte(len(p)))
+		b.WriteString(p)
+	}
+	return vector16(b.Bytes())
+}
+
+// uint16List returns v prefixed by its length in 2 bytes.
+func uint16List(v ...uint16) []byte {
+	var b bytes.Buffer
+	writeUint16(&b, 2*len(v))
+	for _, x := range v {
+		writeUint16(&b, int(x))
+	}
+	return b.Bytes()
+}
+
+// uint8List returns v prefixed by its length in 1 byte.
+func uint8List(v ...uint16) []byte {
+	var b bytes.Buffer
+	b.WriteByte(byte(2 * len(v)))
+	for _, x := range v {
+		writeUint16(&b, int(x))
+	}
+	return b.Bytes()
+}
+
+// vector16 returns data prefixed by its length in 2 bytes.
+func vector16(data []byte) []byte {
+	var b bytes.Buffer
+	writeUint16(&b, len(data))
+	b.Write(data)
+	return b.Bytes()
+}
@@ -0,0 +1,365 @@
+package obfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/ssr"
+)
+
+// newTLS12TicketAuthClient returns a tls1.2_ticket_auth obfs with param, as
+// set up by SSTCPConn.
+func newTLS12TicketAuthClient(param string) *tls12TicketAuth {
+	o := NewObfs("tls1.2_ticket_auth").(*tls12TicketAuth)
+	key := []byte("0123456789abcdef")
+	o.SetServerInfo(&ssr.ServerInfo{Host: "1.2.3.4", Port: 443, Param: param, Key: key, KeyLen: len(key)})
+	o.SetData(o.GetData())
+	return o
+}
+
+func TestTLSProfiles(t *testing.T) {
+	if names := TLSProfiles(); !reflect.DeepEqual(names, []string{"chrome", "firefox", "legacy"}) {
+		t.Fatalf("unexpected profiles: %v", names)
+	}
+	for _, name := range TLSProfiles() {
+		o := newTLS12TicketAuthClient("example.com#" + name)
+		out, err := o.Encode([]byte("payload"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		// the server checks the auth data in the random and the session ID
+		server := newTLS12TicketAuthClient("")
+		hello, n, err := server.verifyClientHello(out)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n != len(out) {
+			t.Errorf("%s: record of %d bytes, sent %d", name, n, len(out))
+		}
+		if !bytes.Equal(hello.sessionID, o.data.localClientID[:]) || hello.serverName != "example.com" || len(hello.ticket) < 64 {
+			t.Errorf("%s: unexpected ClientHello: %+v", name, hello)
+		}
+
+		tampered := append([]byte(nil), out...)
+		tampered[11] ^= 1
+		if _, _, err = server.verifyClientHello(tampered); !errors.Is(err, ssr.ErrTLS12TicketAuthHMACError) {
+			t.Errorf("%s: tampered random: got %v", name, err)
+		}
+		if _, _, err = parseClientHello(out[:len(out)-1]); err == nil {
+			t.Errorf("%s: expected an error for a truncated ClientHello", name)
+		}
+
+		if name == "legacy" {
+			want := []uint16{extRenegotiationInfo, extServerName, extExtendedMasterSec, extSessionTicket,
+				extSignatureAlgorithms, extStatusRequest, extSCT, extChannelID, extECPointFormats,
+				extSupportedGroups, extPadding}
+			if !reflect.DeepEqual(hello.extensions, want) || len(hello.supportedVersions) != 0 {
+				t.Errorf("legacy: unexpected extensions: %#04x", hello.extensions)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(hello.supportedVersions, []uint16{0x0304, 0x0303}) ||
+			!reflect.DeepEqual(hello.alpn, []string{"h2", "http/1.1"}) || hello.cipherSuites[0] != 0x1301 {
+			t.Errorf("%s: unexpected ClientHello: %+v", name, hello)
+		}
+		if len(out)-5 < 0x200 {
+			t.Errorf("%s: ClientHello of %d bytes is not padded", name, len(out)-5)
+		}
+		// the first cipher suite of chrome is GREASE
+		if first := uint16(out[5+4+2+32+1+32+2])<<8 | uint16(out[5+4+2+32+1+32+3]); isGREASE(first) != (name == "chrome") {
+			t.Errorf("%s: first cipher suite %#04x", name, first)
+		}
+	}
+
+	// an IP address has no SNI, and an unknown profile is reported
+	if out, err := newTLS12TicketAuthClient("#chrome").Encode(nil); err != nil {
+		t.Fatal(err)
+	} else if hello, _, err := parseClientHello(out); err != nil || hello.serverName != "" {
+		t.Fatalf("got %+v, %v", hello, err)
+	}
+	if _, err := newTLS12TicketAuthClient("example.com#opera").Encode(nil); !errors.Is(err, ssr.ErrUnsupported) {
+		t.Fatalf("unexpected error for an unknown profile: %v", err)
+	}
+}
+
+func TestWriteKeyShare(t *testing.T) {
+	for group, size := range map[uint16]int{0x001d: 32, 0x0017: 65} {
+		var b bytes.Buffer
+		if err := writeKeyShare(&b, group); err != nil || b.Len() != 4+size {
+			t.Errorf("group %#04x: %d bytes, %v", group, b.Len(), err)
+		}
+	}
+	var b bytes.Buffer
+	if err := writeKeyShare(&b, 0x0018); !errors.Is(err, ssr.ErrUnsupported) || b.Len() != 0 {
+		t.Fatalf("unexpected error for an unknown group: %v", err)
+	}
+
+	grease, err := greaseValues(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[uint16]bool)
+	for _, v := range grease {
+		if !isGREASE(v) || seen[v] {
+			t.Fatalf("unexpected GREASE values: %#04x", grease)
+		}
+		seen[v] = true
+	}
+}
+
+// TestLegacyHello compares the legacy ClientHello with the one sent before
+// there were profiles, byte for byte.
+func TestLegacyHello(t *testing.T) {
+	random := bytes.Repeat([]byte{1}, 32)
+	sessionID := bytes.Repeat([]byte{2}, 32)
+	ticket := bytes.Repeat([]byte{3}, 208)
+	host := "example.com"
+	be16 := func(n int) []byte { return []byte{byte(n >> 8), byte(n)} }
+
+	var ext bytes.Buffer
+	ext.WriteString("\xff\x01\x00\x01\x00")
+	ext.WriteString("\x00\x00")
+	ext.Write(be16(len(host) + 5))
+	ext.Write(be16(len(host) + 3))
+	ext.WriteByte(0)
+	ext.Write(be16(len(host)))
+	ext.WriteString(host)
+	ext.WriteString("\x00\x17\x00\x00\x00\x23")
+	ext.Write(be16(len(ticket)))
+	ext.Write(ticket)
+	ext.WriteString("\x00\x0d\x00\x16\x00\x14\x06\x01\x06\x03\x05\x01\x05\x03\x04\x01\x04\x03\x03\x01\x03\x03\x02\x01\x02\x03")
+	ext.WriteString("\x00\x05\x00\x05\x01\x00\x00\x00\x00")
+	ext.WriteString("\x00\x12\x00\x00")
+	ext.WriteString("\x75\x50\x00\x00")
+	ext.WriteString("\x00\x0b\x00\x02\x01\x00")
+	ext.WriteString("\x00\x0a\x00\x06\x00\x04\x00\x17\x00\x18")
+	ext.WriteString("\x00\x15\x00\x66")
+	ext.Write(make([]byte, 0x66))
+
+	var body bytes.Buffer
+	body.WriteString("\x03\x03")
+	body.Write(random)
+	body.WriteByte(0x20)
+	body.Write(sessionID)
+	body.WriteString("\x00\x1c\xc0\x2b\xc0\x2f\xcc\xa9\xcc\xa8\xcc\x14\xcc\x13\xc0\x0a\xc0\x14\xc0\x09\xc0\x13\x00\x9c\x00\x35\x00\x2f\x00\x0a\x01\x00")
+	body.Write(be16(ext.Len()))
+	body.Write(ext.Bytes())
+
+	var want bytes.Buffer
+	want.WriteString("\x16\x03\x01")
+	want.Write(be16(body.Len() + 4))
+	want.WriteString("\x01\x00")
+	want.Write(be16(body.Len()))
+	want.Write(body.Bytes())
+
+	var got bytes.Buffer
+	if err := tlsProfiles["legacy"].writeClientHello(&got, random, sessionID, host, ticket); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("unexpected legacy ClientHello\n\texpect: %x\n\tgot:    %x", want.Bytes(), got.Bytes())
+	}
+}
+
+// TestParseClientHello parses the ClientHello of crypto/tls.
+func TestParseClientHello(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	go func() {
+		tls.Client(c, &tls.Config{ServerName: "example.com", NextProtos: []string{"h2"}}).Handshake()
+		c.Close()
+	}()
+	var record []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record = append(record, buf[:n]...)
+		hello, l, err := parseClientHello(record)
+		if errors.Is(err, ssr.ErrTLS12TicketAuthTooShortData) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l != len(record) || hello.serverName != "example.com" || !reflect.DeepEqual(hello.alpn, []string{"h2"}) {
+			t.Fatalf("unexpected ClientHello: %+v", hello)
+		}
+		return
+	}
+}
+
+// The server of this module only supports the plain obfs, so the ClientHello
+// is only parsed here, to check the profiles as an SSR server would.
+
+// errIncorrectClientHello is returned by parseClientHello for a record that
+// is not a ClientHello.
+var errIncorrectClientHello = errors.New("incorrect client hello")
+
+// verifyClientHello parses the ClientHello at the start of data and checks its
+// auth data as the server does, with the key and the client ID of its session
+// ID. It returns the ClientHello and its length.
+func (t *tls12TicketAuth) verifyClientHello(data []byte) (*clientHello, int, error) {
+	hello, n, err := parseClientHello(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(hello.sessionID) != 32 {
+		return nil, 0, fmt.Errorf("%w: session id of %d bytes", errIncorrectClientHello, len(hello.sessionID))
+	}
+	t.data = &tlsAuthData{}
+	copy(t.data.localClientID[:], hello.sessionID)
+	if !hmac.Equal(hello.random[32-ssr.ObfsHMACSHA1Len:], t.hmacSHA1(hello.random[:32-ssr.ObfsHMACSHA1Len])) {
+		return nil, 0, ssr.ErrTLS12TicketAuthHMACError
+	}
+	return hello, n, nil
+}
+
+// isGREASE reports whether v is a GREASE value.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>12 == v>>4&0x0f
+}
+
+// clientHello is what parseClientHello reads of a ClientHello, without its
+// GREASE values.
+type clientHello struct {
+	recordVersion     uint16
+	version           uint16
+	random            []byte
+	sessionID         []byte
+	cipherSuites      []uint16
+	extensions        []uint16
+	serverName        string
+	alpn              []string
+	ticket            []byte
+	supportedVersions []uint16
+}
+
+// parseClientHello parses the ClientHello record at the start of data, and
+// returns it with the length of the record. Unknown and GREASE extensions are
+// skipped, and the extensions may be missing.
+func parseClientHello(data []byte) (*clientHello, int, error) {
+	r := &tlsReader{b: data}
+	if len(data) < 5 {
+		return nil, 0, ssr.ErrTLS12TicketAuthTooShortData
+	}
+	if typ := r.uint8(); typ != 0x16 {
+		return nil, 0, fmt.Errorf("%w: record type %#x", errIncorrectClientHello, typ)
+	}
+	h := &clientHello{recordVersion: uint16(r.uint16())}
+	if h.recordVersion>>8 != 0x03 {
+		return nil, 0, fmt.Errorf("%w: record version %#04x", errIncorrectClientHello, h.recordVersion)
+	}
+	record := r.vector(2)
+	if r.err {
+		return nil, 0, ssr.ErrTLS12TicketAuthTooShortData
+	}
+	n := 5 + len(record)
+
+	r = &tlsReader{b: record}
+	if typ := r.uint8(); typ != 0x01 {
+		return nil, 0, fmt.Errorf("%w: handshake type %#x", errIncorrectClientHello, typ)
+	}
+	r = &tlsReader{b: r.vector(3)}
+	h.version = uint16(r.uint16())
+	h.random = r.bytes(32)
+	h.sessionID = r.vector(1)
+	cipherSuites := &tlsReader{b: r.vector(2)}
+	for len(cipherSuites.b) >= 2 {
+		if v := uint16(cipherSuites.uint16()); !isGREASE(v) {
+			h.cipherSuites = append(h.cipherSuites, v)
+		}
+	}
+	r.vector(1) // compression methods
+	if r.err {
+		return nil, 0, fmt.Errorf("%w: truncated", errIncorrectClientHello)
+	}
+	if len(r.b) == 0 {
+		return h, n, nil
+	}
+	extensions := &tlsReader{b: r.vector(2)}
+	for len(extensions.b) > 0 && !extensions.err {
+		typ := uint16(extensions.uint16())
+		e := &tlsReader{b: extensions.vector(2)}
+		if isGREASE(typ) {
+			continue
+		}
+		h.extensions = append(h.extensions, typ)
+		switch typ {
+		case extServerName:
+			names := &tlsReader{b: e.vector(2)}
+			for len(names.b) > 0 && !names.err {
+				nameType := names.uint8()
+				if name := names.vector(2); nameType == 0 && h.serverName == "" {
+					h.serverName = string(name)
+				}
+			}
+		case extALPN:
+			protos := &tlsReader{b: e.vector(2)}
+			for len(protos.b) > 0 && !protos.err {
+				h.alpn = append(h.alpn, string(protos.vector(1)))
+			}
+		case extSessionTicket:
+			h.ticket = e.b
+		case extSupportedVersions:
+			versions := &tlsReader{b: e.vector(1)}
+			for len(versions.b) >= 2 {
+				if v := uint16(versions.uint16()); !isGREASE(v) {
+					h.supportedVersions = append(h.supportedVersions, v)
+				}
+			}
+		}
+	}
+	if extensions.err {
+		return nil, 0, fmt.Errorf("%w: truncated extension", errIncorrectClientHello)
+	}
+	return h, n, nil
+}
+
+// tlsReader reads the fields of a TLS message. Reads past its end return
+// zero values and set err.
+type tlsReader struct {
+	b   []byte
+	err bool
+}
+
+func (r *tlsReader) bytes(n int) []byte {
+	if n > len(r.b) {
+		r.err = true
+		r.b = nil
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *tlsReader) uint(n int) int {
+	v := 0
+	for _, c := range r.bytes(n) {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+func (r *tlsReader) uint8() int {
+	return r.uint(1)
+}
+
+func (r *tlsReader) uint16() int {
+	return r.uint(2)
+}
+
+// vector reads data prefixed by its length in lenBytes bytes.
+func (r *tlsReader) vector(lenBytes int) []byte {
+	return r.bytes(r.uint(lenBytes))
+}
@@ -31,7 +31,14 @@ type tls12TicketAuth struct {
 	sendSaver       bytes.Buffer
 	recvBuffer      bytes.Buffer
 	fastAuth        bool
-	buffer          bytes.Buffer
+	// buffer holds the output of Encode, and decodeBuffer the output of
+	// Decode, as the two directions are used concurrently
+	buffer       bytes.Buffer
+	decodeBuffer bytes.Buffer
+
+	// helloPending is set when the handshake was finished by Flush before
+	// the server hello was received
+	helloPending bool
 }
 
 // newTLS12TicketAuth create a tlv1.2_ticket_auth object
@@ -71,17 +78,19 @@ func (t *tls12TicketAuth) GetData() interface{} {
 	return t.data
 }
 
-func (t *tls12TicketAuth) getHost() string {
+// getHost returns one of the comma separated hosts of the obfs param, or the
+// server host if there is none and it is not an IP address.
+func (t *tls12TicketAuth) getHost(param string) string {
 	host := t.Host
-	if len(t.Param) > 0 {
-		hosts := strings.Split(t.Param, ",")
+	if len(param) > 0 {
+		hosts := strings.Split(param, ",")
 		if len(hosts) > 0 {
 
 			host = hosts[rand.Intn(len(hosts))]
 			host = strings.TrimSpace(host)
 		}
 	}
-	if len(host) > 0 && host[len(host)-1] >= byte('0') && host[len(host)-1] <= byte('9') && len(t.Param) == 0 {
+	if len(host) > 0 && host[len(host)-1] >= byte('0') && host[len(host)-1] <= byte('9') && len(param) == 0 {
 		host = ""
 	}
 	return host
@@ -156,72 +165,23 @@ func (t *tls12TicketAuth) Encode(data []byte) ([]byte, error) {
 		t.handshakeStatus = 8
 		return t.buffer.Bytes(), nil
 	case 0:
-		tlsData0 := []byte("\x00\x1c\xc0\x2b\xc0\x2f\xcc\xa9\xcc\xa8\xcc\x14\xcc\x13\xc0\x0a\xc0\x14\xc0\x09\xc0\x13\x00\x9c\x00\x35\x00\x2f\x00\x0a\x01\x00")
-		tlsData1 := []byte("\xff\x01\x00\x01\x00")
-		tlsData2 := []byte("\x00\x17\x00\x00\x00\x23\x00\xd0")
-		tlsData3 := []byte("\x00\x0d\x00\x16\x00\x14\x06\x01\x06\x03\x05\x01\x05\x03\x04\x01\x04\x03\x03\x01\x03\x03\x02\x01\x02\x03\x00\x05\x00\x05\x01\x00\x00\x00\x00\x00\x12\x00\x00\x75\x50\x00\x00\x00\x0b\x00\x02\x01\x00\x00\x0a\x00\x06\x00\x04\x00\x17\x00\x18\x00\x15\x00\x66\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
-
-		var tlsData [2048]byte
-		tlsDataLen := 0
-		copy(tlsData[0:], tlsData1)
-		tlsDataLen += len(tlsData1)
-		sni := t.sni(t.getHost())
-		copy(tlsData[tlsDataLen:], sni)
-		tlsDataLen += len(sni)
-		copy(tlsData[tlsDataLen:], tlsData2)
-		tlsDataLen += len(tlsData2)
-		ticketLen := rand.Intn(164)*2 + 64
-		tlsData[tlsDataLen-1] = uint8(ticketLen & 0xff)
-		tlsData[tlsDataLen-2] = uint8(ticketLen >> 8)
-		//ticketLen := 208
-		rand.Read(tlsData[tlsDataLen : tlsDataLen+ticketLen])
-		tlsDataLen += ticketLen
-		copy(tlsData[tlsDataLen:], tlsData3)
-		tlsDataLen += len(tlsData3)
-
-		length := 11 + 32 + 1 + 32 + len(tlsData0) + 2 + tlsDataLen
-		encodedData := make([]byte, length)
-		pdata := length - tlsDataLen
-		l := tlsDataLen
-		copy(encodedData[pdata:], tlsData[:tlsDataLen])
-		encodedData[pdata-1] = uint8(tlsDataLen)
-		encodedData[pdata-2] = uint8(tlsDataLen >> 8)
-		pdata -= 2
-		l += 2
-		copy(encodedData[pdata-len(tlsData0):], tlsData0)
-		pdata -= len(tlsData0)
-		l += len(tlsData0)
-		copy(encodedData[pdata-32:], t.data.localClientID[:])
-		pdata -= 32
-		l += 32
-		encodedData[pdata-1] = 0x20
-		pdata -= 1
-		l += 1
-		copy(encodedData[pdata-32:], t.packAuthData())
-		pdata -= 32
-		l += 32
-		encodedData[pdata-1] = 0x3
-		encodedData[pdata-2] = 0x3 // tls version
-		pdata -= 2
-		l += 2
-		encodedData[pdata-1] = uint8(l)
-		encodedData[pdata-2] = uint8(l >> 8)
-		encodedData[pdata-3] = 0
-		encodedData[pdata-4] = 1
-		pdata -= 4
-		l += 4
-		encodedData[pdata-1] = uint8(l)
-		encodedData[pdata-2] = uint8(l >> 8)
-		pdata -= 2
-		l += 2
-		encodedData[pdata-1] = 0x1
-		encodedData[pdata-2] = 0x3 // tls version
-		pdata -= 2
-		l += 2
-		encodedData[pdata-1] = 0x16 // tls handshake
-		pdata -= 1
-		l += 1
-		packData(&t.sendSaver, data)
+		hosts, name := SplitTLSProfile(t.Param)
+		if name == "" {
+			name = DefaultTLSProfile
+		}
+		profile, ok := tlsProfiles[name]
+		if !ok {
+			return nil, &ssr.UnsupportedError{Kind: "tls1.2_ticket_auth profile", Name: name}
+		}
+		ticket := make([]byte, rand.Intn(164)*2+64)
+		rand.Read(ticket)
+		if err := profile.writeClientHello(&t.buffer, t.packAuthData(), t.data.localClientID[:], t.getHost(hosts), ticket); err != nil {
+			return nil, err
+		}
+		encodedData := append([]byte(nil), t.buffer.Bytes()...)
+		if len(data) > 0 {
+			packData(&t.sendSaver, data)
+		}
 		t.handshakeStatus = 1
 		return encodedData, nil
 	default:
@@ -229,33 +189,25 @@ func (t *tls12TicketAuth) Encode(data []byte) ([]byte, error) {
 	}
 }
 
+func (t *tls12TicketAuth) HandshakeDone() bool {
+	return t.handshakeStatus == 8
+}
+
+func (t *tls12TicketAuth) Flush() ([]byte, error) {
+	if t.handshakeStatus != 1 {
+		return nil, nil
+	}
+	t.helloPending = true
+	return t.Encode(nil)
+}
+
 func (t *tls12TicketAuth) Decode(data []byte) (decodedData []byte, needSendBack bool, err error) {
 	if t.handshakeStatus == -1 {
 		return data, false, nil
 	}
-	t.buffer.Reset()
-	if t.handshakeStatus == 8 {
-		t.recvBuffer.Write(data)
-		for t.recvBuffer.Len() > 5 {
-			var h [5]byte
-			_, _ = t.recvBuffer.Read(h[:])
-			if !bytes.Equal(h[0:3], []byte{0x17, 0x3, 0x3}) {
-				return nil, false, fmt.Errorf("%w: incorrect magic number: %v, 0x170303 is expected", ssr.ErrTLS12TicketAuthIncorrectMagicNumber, h[0:3])
-			}
-			size := int(binary.BigEndian.Uint16(h[3:5]))
-			if t.recvBuffer.Len() < size {
-				// read it next time
-				unread := t.recvBuffer.Bytes()
-				t.recvBuffer.Reset()
-				t.recvBuffer.Write(h[:])
-				t.recvBuffer.Write(unread)
-				break
-			}
-			d := make([]byte, size)
-			_, _ = t.recvBuffer.Read(d)
-			t.buffer.Write(d)
-		}
-		return t.buffer.Bytes(), false, nil
+	t.decodeBuffer.Reset()
+	if t.handshakeStatus == 8 && !t.helloPending {
+		return t.decodeRecords(data)
 	}
 
 	if len(data) < 11+32+1+32 {
@@ -267,9 +219,56 @@ func (t *tls12TicketAuth) Decode(data []byte) (decodedData []byte, needSendBack
 	if !hmac.Equal(data[33:33+ssr.ObfsHMACSHA1Len], hash) {
 		return nil, false, ssr.ErrTLS12TicketAuthHMACError
 	}
+	if t.helloPending {
+		// the handshake finish has already been sent, and the server may
+		// have sent data right after its hello
+		t.helloPending = false
+		return t.decodeRecords(data[serverHelloLen(data):])
+	}
 	return nil, true, nil
 }
 
+// decodeRecords returns the payload of the application data records of data,
+// keeping an incomplete record for the next call.
+func (t *tls12TicketAuth) decodeRecords(data []byte) ([]byte, bool, error) {
+	t.recvBuffer.Write(data)
+	for t.recvBuffer.Len() > 5 {
+		var h [5]byte
+		_, _ = t.recvBuffer.Read(h[:])
+		if !bytes.Equal(h[0:3], []byte{0x17, 0x3, 0x3}) {
+			return nil, false, fmt.Errorf("%w: incorrect magic number: %v, 0x170303 is expected", ssr.ErrTLS12TicketAuthIncorrectMagicNumber, h[0:3])
+		}
+		size := int(binary.BigEndian.Uint16(h[3:5]))
+		if t.recvBuffer.Len() < size {
+			// read it next time
+			unread := t.recvBuffer.Bytes()
+			t.recvBuffer.Reset()
+			t.recvBuffer.Write(h[:])
+			t.recvBuffer.Write(unread)
+			break
+		}
+		d := make([]byte, size)
+		_, _ = t.recvBuffer.Read(d)
+		t.decodeBuffer.Write(d)
+	}
+	return t.decodeBuffer.Bytes(), false, nil
+}
+
+// serverHelloLen returns the length of the records of the server hello at the
+// start of data: the handshake and change cipher spec records before the
+// first application data record.
+func serverHelloLen(data []byte) int {
+	n := 0
+	for n+5 <= len(data) && data[n] != 0x17 {
+		n += 5 + int(binary.BigEndian.Uint16(data[n+3:n+5]))
+	}
+	if n >= len(data) || data[n] != 0x17 {
+		// the hello is all there is, or is cut short
+		return len(data)
+	}
+	return n
+}
+
 func (t *tls12TicketAuth) packAuthData() (outData []byte) {
 	outSize := 32
 	outData = make([]byte, outSize)
@@ -294,19 +293,6 @@ func (t *tls12TicketAuth) hmacSHA1(data []byte) []byte {
 	return sha1Data[:ssr.ObfsHMACSHA1Len]
 }
 
-func (t *tls12TicketAuth) sni(u string) []byte {
-	bURL := []byte(u)
-	length := len(bURL)
-	ret := make([]byte, length+9)
-	copy(ret[9:9+length], bURL)
-	binary.BigEndian.PutUint16(ret[7:], uint16(length&0xFFFF))
-	length += 3
-	binary.BigEndian.PutUint16(ret[4:], uint16(length&0xFFFF))
-	length += 2
-	binary.BigEndian.PutUint16(ret[2:], uint16(length&0xFFFF))
-	return ret
-}
-
 func (t *tls12TicketAuth) GetOverhead() int {
 	return 5
 }
@@ -0,0 +1,63 @@
+package obfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// record returns a TLS record of typ with payload.
+func record(typ byte, payload []byte) []byte {
+	b := []byte{typ, 0x03, 0x03, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+// serverHello returns the records of the server hello of tls1.2_ticket_auth
+// answering o, as sent by the server: ServerHello, ChangeCipherSpec and
+// Finished.
+func serverHello(o *tls12TicketAuth) []byte {
+	hello := make([]byte, 4+2+32+1+32+10)
+	hello[0] = 0x02
+	binary.BigEndian.PutUint16(hello[2:], uint16(len(hello)-4))
+	copy(hello[4:], "\x03\x03")
+	auth := hello[6 : 6+32]
+	copy(auth[22:], o.hmacSHA1(auth[:22]))
+	hello[6+32] = 0x20
+	copy(hello[6+32+1:], o.data.localClientID[:])
+
+	b := record(0x16, hello)
+	b = append(b, record(0x14, []byte{1})...)
+	return append(b, record(0x16, make([]byte, 32))...)
+}
+
+// TestDecodeHelloWithData receives the server hello along with the first
+// data records, after the handshake was finished by Flush.
+func TestDecodeHelloWithData(t *testing.T) {
+	o := newTLS12TicketAuthClient("")
+	if _, err := o.Encode([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := append(serverHello(o), record(0x17, []byte("pong"))...)
+	next := record(0x17, []byte("and more"))
+	data = append(data, next[:7]...)
+	decoded, sendBack, err := o.Decode(data)
+	if err != nil || sendBack || !bytes.Equal(decoded, []byte("pong")) {
+		t.Fatalf("got %q, %v, %v", decoded, sendBack, err)
+	}
+	if decoded, _, err = o.Decode(next[7:]); err != nil || !bytes.Equal(decoded, []byte("and more")) {
+		t.Fatalf("got %q, %v", decoded, err)
+	}
+
+	// the hello alone gives no data
+	o = newTLS12TicketAuthClient("")
+	o.Encode(nil)
+	o.Flush()
+	if decoded, _, err = o.Decode(serverHello(o)); err != nil || len(decoded) != 0 {
+		t.Fatalf("got %q, %v", decoded, err)
+	}
+}
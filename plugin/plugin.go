@@ -0,0 +1,309 @@
+// Package plugin runs SIP003 plugins, such as obfs-local or v2ray-plugin,
+// which carry the Shadowsocks connections of a local port to a server.
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/v2rayA/shadowsocksR/tools"
+)
+
+const (
+	// startTimeout bounds the time a plugin takes to listen on its local port
+	// once started.
+	startTimeout = 5 * time.Second
+	// stopTimeout is how long a plugin may take to exit once interrupted.
+	stopTimeout = 3 * time.Second
+	// stableRun is how long a plugin must run for its restart delay to be reset.
+	stableRun = time.Minute
+
+	maxBackoff = time.Minute
+)
+
+// ErrClosed is returned by Start and WaitReady after Close.
+var ErrClosed = errors.New("[plugin] closed")
+
+// Parse splits the plugin parameter of a SIP002 link, the plugin name
+// followed by semicolon separated options, as in "obfs-local;obfs=http".
+func Parse(s string) (name, options string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// Plugin is a SIP003 plugin process. It listens on LocalAddr and forwards
+// the connections to the server. The process is restarted when it exits,
+// until Close is called.
+type Plugin struct {
+	log *logrus.Logger
+
+	path       string
+	options    string
+	remoteHost string
+	remotePort string
+	localAddr  string
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	started time.Time
+	exited  chan struct{}
+	// ready is closed once the process runs, and replaced when it exits
+	ready  chan struct{}
+	closed bool
+	die    chan struct{}
+}
+
+// New returns the plugin described by the plugin parameter of a link, for the
+// server at remoteAddr. The plugin executable is looked up in PATH unless
+// its name is a path.
+func New(plugin, remoteAddr string, log *logrus.Logger) (*Plugin, error) {
+	name, options := Parse(plugin)
+	if name == "" {
+		return nil, errors.New("[plugin] empty plugin name")
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("[plugin] %w", err)
+	}
+	host, port, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("[plugin] %w", err)
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &Plugin{
+		log:        log,
+		path:       path,
+		options:    options,
+		remoteHost: host,
+		remotePort: port,
+		die:        make(chan struct{}),
+	}, nil
+}
+
+// LocalAddr returns the address the plugin listens on, once started.
+func (p *Plugin) LocalAddr() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.localAddr
+}
+
+// Start starts the plugin on a free local port. It does not wait for the
+// plugin to listen, which Dial does.
+func (p *Plugin) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.cmd != nil {
+		return nil
+	}
+	port, err := freePort()
+	if err != nil {
+		return fmt.Errorf("[plugin] %w", err)
+	}
+	p.localAddr = net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	if err = p.start(); err != nil {
+		return err
+	}
+	p.ready = make(chan struct{})
+	close(p.ready)
+	go p.supervise()
+	return nil
+}
+
+// WaitReady waits for the plugin process to run while it is being restarted,
+// for the time a start may take at most.
+func (p *Plugin) WaitReady() error {
+	p.mu.Lock()
+	ready, closed := p.ready, p.closed
+	p.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if ready == nil {
+		return errors.New("[plugin] not started")
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-p.die:
+		return ErrClosed
+	case <-time.After(startTimeout):
+		return fmt.Errorf("[plugin] %v is not listening on %v", p.path, p.LocalAddr())
+	}
+}
+
+// Dial connects to LocalAddr once the plugin runs.
+//
+// The local port is not probed for readiness, as the plugin forwards every
+// connection to the server, which would log the probes as failed handshakes.
+// Instead, a failed connection is retried until the process has run for
+// startTimeout, since it may not listen yet.
+func (p *Plugin) Dial(timeout time.Duration) (net.Conn, error) {
+	if err := p.WaitReady(); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	addr, started, exited := p.localAddr, p.started, p.exited
+	p.mu.Unlock()
+	for {
+		c, err := net.DialTimeout("tcp", addr, timeout)
+		if err == nil {
+			return c, nil
+		}
+		if time.Since(started) > startTimeout {
+			return nil, fmt.Errorf("[plugin] %v is not listening: %w", p.path, err)
+		}
+		select {
+		case <-exited:
+			return nil, fmt.Errorf("[plugin] %v exited: %w", p.path, err)
+		case <-p.die:
+			return nil, ErrClosed
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// start runs the process. p.mu must be held.
+func (p *Plugin) start() error {
+	host, port, _ := net.SplitHostPort(p.localAddr)
+	cmd := exec.Command(p.path)
+	cmd.Env = append(os.Environ(),
+		"SS_REMOTE_HOST="+p.remoteHost,
+		"SS_REMOTE_PORT="+p.remotePort,
+		"SS_LOCAL_HOST="+host,
+		"SS_LOCAL_PORT="+port,
+		"SS_PLUGIN_OPTIONS="+p.options,
+	)
+	stdout, stderr := p.log.WriterLevel(logrus.InfoLevel), p.log.WriterLevel(logrus.WarnLevel)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		stdout.Close()
+		stderr.Close()
+		return fmt.Errorf("[plugin] %w", err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+		close(exited)
+	}()
+	p.cmd, p.started, p.exited = cmd, time.Now(), exited
+	p.log.Infof("[plugin] started %v, %v <-> %v", p.path, p.localAddr, net.JoinHostPort(p.remoteHost, p.remotePort))
+	return nil
+}
+
+// supervise restarts the process when it exits, with an increasing delay
+// while it keeps exiting quickly.
+func (p *Plugin) supervise() {
+	var backoff time.Duration
+	for {
+		p.mu.Lock()
+		exited, started := p.exited, p.started
+		p.mu.Unlock()
+		select {
+		case <-exited:
+		case <-p.die:
+			return
+		}
+		p.mu.Lock()
+		p.ready = make(chan struct{})
+		p.mu.Unlock()
+		if time.Since(started) > stableRun {
+			backoff = 0
+		}
+		if backoff == 0 {
+			backoff = time.Second
+		} else if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		p.log.Warnf("[plugin] %v exited, restart in %v", p.path, backoff)
+		for {
+			select {
+			case <-time.After(backoff):
+			case <-p.die:
+				return
+			}
+			err := p.restart()
+			if err == nil {
+				break
+			}
+			if errors.Is(err, ErrClosed) {
+				return
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			p.log.Warnf("[plugin] failed to restart %v, retry in %v: %v", p.path, backoff, err)
+		}
+	}
+}
+
+// restart starts the process again on the same port, and marks the plugin
+// ready.
+func (p *Plugin) restart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if err := p.start(); err != nil {
+		return err
+	}
+	close(p.ready)
+	return nil
+}
+
+// Close stops the plugin.
+func (p *Plugin) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.die)
+	if p.cmd != nil {
+		p.stop()
+	}
+	return nil
+}
+
+// stop interrupts the process, and kills it if it does not exit in time.
+// p.mu must be held.
+func (p *Plugin) stop() {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+}
+
+// freePort returns a local TCP port that is not in use.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,140 @@
+package plugin
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+)
+
+// echoThrough checks that p is connected to an echo server.
+func echoThrough(t *testing.T, p *Plugin) {
+	c, err := p.Dial(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testutil.EchoThrough(t, c)
+}
+
+// listenCount starts an echo server that counts its connections.
+func listenCount(t *testing.T) (net.Listener, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n int32
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&n, 1)
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l, &n
+}
+
+// buildStub builds the stub plugin of testdata into dir.
+func buildStub(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "stub")
+	if runtime.GOOS == "windows" {
+		path += ".exe"
+	}
+	out, err := exec.Command("go", "build", "-o", path, "./testdata/stub").CombinedOutput()
+	if err != nil {
+		t.Skipf("cannot build the stub plugin: %v\n%s", err, out)
+	}
+	return path
+}
+
+func TestParse(t *testing.T) {
+	for s, want := range map[string][2]string{
+		"obfs-local;obfs=http;obfs-host=example.com": {"obfs-local", "obfs=http;obfs-host=example.com"},
+		"v2ray-plugin": {"v2ray-plugin", ""},
+	} {
+		if name, options := Parse(s); name != want[0] || options != want[1] {
+			t.Errorf("%s: got %q, %q, want %q, %q", s, name, options, want[0], want[1])
+		}
+	}
+}
+
+func TestPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stub := buildStub(t, dir)
+
+	echo, conns := listenCount(t)
+	defer echo.Close()
+
+	// the stub exits after one connection, and nothing but the first Dial
+	// reaches the server
+	p, err := New(stub+";exit=1", echo.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err = p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(conns); n != 0 {
+		t.Fatalf("%d connection(s) forwarded before the first Dial", n)
+	}
+	echoThrough(t, p)
+	if n := atomic.LoadInt32(conns); n != 1 {
+		t.Fatalf("%d connection(s) forwarded by one Dial", n)
+	}
+
+	// it is restarted on the same port, and WaitReady returns once it runs
+	p.mu.Lock()
+	exited := p.exited
+	p.mu.Unlock()
+	<-exited
+	exitedAt := time.Now()
+	time.Sleep(100 * time.Millisecond)
+	if err = p.WaitReady(); err != nil {
+		t.Fatal(err)
+	}
+	echoThrough(t, p)
+	p.mu.Lock()
+	started := p.started
+	p.mu.Unlock()
+	if started.Before(exitedAt) {
+		t.Errorf("start time %v not updated by the restart at %v", started, exitedAt)
+	}
+
+	p.Close()
+	if c, err := net.DialTimeout("tcp", p.LocalAddr(), time.Second); err == nil {
+		c.Close()
+		t.Fatal("plugin still listening after Close")
+	}
+	if err = p.WaitReady(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+	if _, err = p.Dial(time.Second); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from Dial after Close, got %v", err)
+	}
+}
+
+func TestNewMissing(t *testing.T) {
+	if _, err := New("no-such-plugin-4e8b;obfs=http", "127.0.0.1:8388", nil); err == nil {
+		t.Fatal("expected an error for a missing plugin")
+	}
+}
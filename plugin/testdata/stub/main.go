@@ -0,0 +1,53 @@
+// Command stub is a SIP003 plugin for the tests. It forwards the
+// connections of its local port to the remote address without changing
+// them. The option exit=N makes it exit after N connections.
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+func main() {
+	local := net.JoinHostPort(os.Getenv("SS_LOCAL_HOST"), os.Getenv("SS_LOCAL_PORT"))
+	remote := net.JoinHostPort(os.Getenv("SS_REMOTE_HOST"), os.Getenv("SS_REMOTE_PORT"))
+	exitAfter := -1
+	for _, opt := range strings.Split(os.Getenv("SS_PLUGIN_OPTIONS"), ";") {
+		if strings.HasPrefix(opt, "exit=") {
+			exitAfter, _ = strconv.Atoi(opt[len("exit="):])
+		}
+	}
+
+	l, err := net.Listen("tcp", local)
+	if err != nil {
+		os.Exit(1)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n != exitAfter; n++ {
+		c, err := l.Accept()
+		if err != nil {
+			os.Exit(1)
+		}
+		rc, err := net.Dial("tcp", remote)
+		if err != nil {
+			c.Close()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			go func() {
+				io.Copy(rc, c)
+				rc.Close()
+			}()
+			io.Copy(c, rc)
+			c.Close()
+		}()
+	}
+	l.Close()
+	wg.Wait()
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"github.com/v2rayA/shadowsocksR/ssr"
 	"github.com/v2rayA/shadowsocksR/tools"
+	"sort"
 	"strings"
 )
 
@@ -35,10 +36,23 @@ func register(name string, c creator) {
 	creatorMap[name] = c
 }
 
+// NewProtocol creates a protocol object by name.
+// The "_compatible" suffix of a name, which lets a server accept origin
+// clients too, is ignored.
 func NewProtocol(name string) IProtocol {
-	c, ok := creatorMap[strings.ToLower(name)]
+	c, ok := creatorMap[strings.TrimSuffix(strings.ToLower(name), "_compatible")]
 	if ok {
 		return c()
 	}
 	return nil
 }
+
+// Names returns the sorted names of the registered protocols.
+func Names() []string {
+	names := make([]string, 0, len(creatorMap))
+	for name := range creatorMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
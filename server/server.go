@@ -0,0 +1,298 @@
+// Package server implements the server side of SSR connections.
+//
+// Only the plain obfs and the origin protocol are supported on the server
+// side, which is plain Shadowsocks with a stream cipher: the other obfs and
+// protocols of this module only implement their client side.
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	shadowsocksr "github.com/v2rayA/shadowsocksR"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/mux"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/socks"
+	"golang.org/x/net/proxy"
+)
+
+// handshakeTimeout bounds the time a client takes to send its destination.
+const handshakeTimeout = 30 * time.Second
+
+// supported obfs and protocols on the server side
+var (
+	supportedObfs      = map[string]bool{"plain": true}
+	supportedProtocols = map[string]bool{"origin": true}
+)
+
+// Stats are the traffic counters of a Server.
+type Stats struct {
+	// Conns is the number of open connections.
+	Conns int
+	// TotalConns is the number of accepted connections.
+	TotalConns uint64
+	// BytesIn is the number of bytes received from clients, after decryption.
+	BytesIn uint64
+	// BytesOut is the number of bytes sent to clients, before encryption.
+	BytesOut uint64
+}
+
+// Server serves SSR connections of a config, connecting their destinations
+// with a dialer.
+type Server struct {
+	log    *logrus.Logger
+	cfg    config.Config
+	dialer proxy.Dialer
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	closed    bool
+
+	totalConns uint64
+	bytesIn    uint64
+	bytesOut   uint64
+}
+
+// New returns a server of cfg, whose Server and Port are only used by
+// ListenAndServe. Destinations are connected with d.
+func New(cfg *config.Config, d proxy.Dialer, log *logrus.Logger) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.SetDefaults()
+	if !supportedObfs[c.Obfs] {
+		return nil, &ssr.UnsupportedError{Kind: "server side obfs", Name: c.Obfs}
+	}
+	if !supportedProtocols[c.Protocol] {
+		return nil, &ssr.UnsupportedError{Kind: "server side protocol", Name: c.Protocol}
+	}
+	if log == nil {
+		log = tools.NewFatalLogger()
+	}
+	return &Server{
+		log:       log,
+		cfg:       c,
+		dialer:    d,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// ListenAndServe listens on the address and port of the config.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.cfg.Addr())
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l and serves them until l or the server is
+// closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(c) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrack(c)
+			s.ServeConn(c)
+		}()
+	}
+}
+
+// ErrServerClosed is returned by Serve after Shutdown.
+var ErrServerClosed = errors.New("[server] closed")
+
+// ServeConn serves a single SSR connection.
+func (s *Server) ServeConn(c net.Conn) {
+	atomic.AddUint64(&s.totalConns, 1)
+	host, port := s.cfg.Server, uint16(s.cfg.Port)
+	if a, ok := c.LocalAddr().(*net.TCPAddr); ok {
+		host, port = a.IP.String(), uint16(a.Port)
+	}
+	ssconn, err := shadowsocksr.NewSSTCPConnFromConfig(c, &s.cfg, host, port)
+	if err != nil {
+		s.log.Warnf("[server] %v: %v", c.RemoteAddr(), err)
+		return
+	}
+	defer ssconn.Close()
+
+	ssconn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	target, err := socks.ReadAddr(ssconn)
+	if err != nil {
+		s.log.Warnf("[server] %v: read destination: %v", c.RemoteAddr(), err)
+		return
+	}
+	ssconn.SetReadDeadline(time.Time{})
+
+	var conn net.Conn = &countConn{Conn: ssconn, in: &s.bytesIn, out: &s.bytesOut}
+	if s.cfg.Timeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: s.cfg.Timeout}
+	}
+	if target.String() == mux.Addr {
+		s.log.Infof("[server] %v <-> mux", c.RemoteAddr())
+		mux.Serve(conn, s.dialer, s.log)
+		return
+	}
+
+	rc, err := s.dialer.Dial("tcp", target.String())
+	if err != nil {
+		s.log.Warnf("[server] failed to connect to %v: %v", target, err)
+		return
+	}
+	defer rc.Close()
+	s.log.Infof("[server] %v <-> %v", c.RemoteAddr(), target)
+	tools.Relay(conn, rc)
+}
+
+// Shutdown stops accepting connections and waits for the open ones to
+// finish. When ctx is done, the remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+// Stats returns the traffic counters of the server.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	conns := len(s.conns)
+	s.mu.Unlock()
+	return Stats{
+		Conns:      conns,
+		TotalConns: atomic.LoadUint64(&s.totalConns),
+		BytesIn:    atomic.LoadUint64(&s.bytesIn),
+		BytesOut:   atomic.LoadUint64(&s.bytesOut),
+	}
+}
+
+// Port returns the port of the config, to name the server in reports.
+func (s *Server) Port() string {
+	return strconv.Itoa(s.cfg.Port)
+}
+
+func (s *Server) track(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	c.Close()
+	s.wg.Done()
+}
+
+// countConn counts the bytes read from and written to a client.
+type countConn struct {
+	net.Conn
+	in, out *uint64
+}
+
+func (c *countConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	atomic.AddUint64(c.in, uint64(n))
+	return
+}
+
+func (c *countConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	atomic.AddUint64(c.out, uint64(n))
+	return
+}
+
+func (c *countConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("[server] the connection does not support half-close")
+}
+
+// idleConn closes a connection that has no traffic for timeout.
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	return c.Conn.Write(b)
+}
+
+func (c *idleConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return errors.New("[server] the connection does not support half-close")
+}
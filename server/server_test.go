@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/client"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"golang.org/x/net/proxy"
+)
+
+func TestNewUnsupported(t *testing.T) {
+	for _, cfg := range []*config.Config{
+		{Server: "127.0.0.1", Port: 8388, Password: "pw", Obfs: "http_simple"},
+		{Server: "127.0.0.1", Port: 8388, Password: "pw", Protocol: "auth_chain_a", ProtocolParam: "1:pw"},
+	} {
+		if _, err := New(cfg, proxy.Direct, nil); !errors.Is(err, ssr.ErrUnsupported) {
+			t.Errorf("obfs %q, protocol %q: expected an unsupported error, got %v", cfg.Obfs, cfg.Protocol, err)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	cfg := &config.Config{Server: "127.0.0.1", Port: port, Method: "aes-256-cfb", Password: "Alice's secret"}
+	s, err := New(cfg, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(l) }()
+
+	cfg.DialTimeout = 5 * time.Second
+	d, err := client.NewSSRFromConfig(cfg, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	msg := bytes.Repeat([]byte("Don't tell me the moon is shining"), 1000)
+	go c.Write(msg)
+	buf := make([]byte, len(msg))
+	if _, err = io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, msg) {
+		t.Fatal("echoed data differs")
+	}
+	if st := s.Stats(); st.Conns != 1 || st.TotalConns != 1 || st.BytesIn < uint64(len(msg)) || st.BytesOut < uint64(len(msg)) {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+
+	// the open connection is closed when the grace period is over
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Fatalf("expected %v, got %v", ErrServerClosed, err)
+	}
+	if _, err = c.Read(buf); err == nil {
+		t.Fatal("connection still open after shutdown")
+	}
+	if _, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+		t.Fatal("listener still open after shutdown")
+	}
+}
@@ -0,0 +1,136 @@
+package ssr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error kinds. Every error type below matches its kind with errors.Is, so that
+// errors.Is(err, ErrAuth) tells a wrong password apart from a network failure
+// without inspecting the type.
+var (
+	ErrDial        = errors.New("dial failure")
+	ErrHandshake   = errors.New("obfs handshake failure")
+	ErrAuth        = errors.New("authentication failure")
+	ErrProtocol    = errors.New("protocol framing error")
+	ErrUnsupported = errors.New("unsupported")
+	ErrConfig      = errors.New("config error")
+)
+
+// DialError is returned when the connection to the server cannot be opened.
+type DialError struct {
+	Addr string
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	return fmt.Sprintf("[ssr] dial to %s error: %v", e.Addr, e.Err)
+}
+
+func (e *DialError) Unwrap() error        { return e.Err }
+func (e *DialError) Is(target error) bool { return target == ErrDial }
+
+// HandshakeError is returned when the obfs handshake with the server fails.
+type HandshakeError struct {
+	Addr string
+	Obfs string
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("[ssr] %s handshake with %s failed: %v", e.Obfs, e.Addr, e.Err)
+}
+
+func (e *HandshakeError) Unwrap() error        { return e.Err }
+func (e *HandshakeError) Is(target error) bool { return target == ErrHandshake }
+
+// AuthError is returned when the server data fails an HMAC check, which
+// usually means a wrong password or protocol parameter.
+type AuthError struct {
+	Addr     string
+	Obfs     string
+	Protocol string
+	Err      error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("[ssr] authentication with %s failed (obfs %s, protocol %s): %v", e.Addr, e.Obfs, e.Protocol, e.Err)
+}
+
+func (e *AuthError) Unwrap() error        { return e.Err }
+func (e *AuthError) Is(target error) bool { return target == ErrAuth }
+
+// ProtocolError is returned when the data of the server cannot be decoded by
+// the protocol, such as a length or checksum mismatch.
+type ProtocolError struct {
+	Addr     string
+	Protocol string
+	Err      error
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("[ssr] %s error from %s: %v", e.Protocol, e.Addr, e.Err)
+}
+
+func (e *ProtocolError) Unwrap() error        { return e.Err }
+func (e *ProtocolError) Is(target error) bool { return target == ErrProtocol }
+
+// UnsupportedError is returned for an unknown encrypt method, obfs or protocol.
+type UnsupportedError struct {
+	// Kind is "method", "obfs" or "protocol".
+	Kind string
+	Name string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("[ssr] unsupported %s type: %s", e.Kind, e.Name)
+}
+
+func (e *UnsupportedError) Is(target error) bool { return target == ErrUnsupported }
+
+// ConfigError is returned for an invalid server configuration.
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("[ssr] invalid config: %v", e.Err)
+	}
+	return fmt.Sprintf("[ssr] invalid config %s: %v", e.Field, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error        { return e.Err }
+func (e *ConfigError) Is(target error) bool { return target == ErrConfig }
+
+var (
+	authErrors = []error{
+		ErrAuthAES128IncorrectHMAC,
+		ErrAuthChainIncorrectHMAC,
+		ErrTLS12TicketAuthHMACError,
+	}
+	handshakeErrors = []error{
+		ErrTLS12TicketAuthTooShortData,
+		ErrTLS12TicketAuthIncorrectMagicNumber,
+		ErrSimpleObfsHTTPIncorrectResponse,
+		ErrSimpleObfsTLSIncorrectRecord,
+	}
+)
+
+// NewDecodeError classifies err, returned by the obfs or the protocol while
+// decoding the data of the server at addr, as an AuthError, a HandshakeError
+// or a ProtocolError.
+func NewDecodeError(addr, obfs, protocol string, err error) error {
+	for _, e := range authErrors {
+		if errors.Is(err, e) {
+			return &AuthError{Addr: addr, Obfs: obfs, Protocol: protocol, Err: err}
+		}
+	}
+	for _, e := range handshakeErrors {
+		if errors.Is(err, e) {
+			return &HandshakeError{Addr: addr, Obfs: obfs, Err: err}
+		}
+	}
+	return &ProtocolError{Addr: addr, Protocol: protocol, Err: err}
+}
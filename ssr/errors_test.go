@@ -0,0 +1,75 @@
+package ssr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	kinds := []error{ErrDial, ErrHandshake, ErrAuth, ErrProtocol, ErrUnsupported, ErrConfig}
+	for _, test := range []struct {
+		err  error
+		kind error
+		msg  string
+	}{
+		{&DialError{Addr: "1.2.3.4:443", Err: io.EOF}, ErrDial, "dial to 1.2.3.4:443"},
+		{&HandshakeError{Addr: "1.2.3.4:443", Obfs: "tls1.2_ticket_auth", Err: io.EOF}, ErrHandshake, "tls1.2_ticket_auth handshake"},
+		{&AuthError{Addr: "1.2.3.4:443", Obfs: "plain", Protocol: "auth_chain_a", Err: io.EOF}, ErrAuth, "protocol auth_chain_a"},
+		{&ProtocolError{Addr: "1.2.3.4:443", Protocol: "auth_aes128_md5", Err: io.EOF}, ErrProtocol, "auth_aes128_md5 error"},
+		{&UnsupportedError{Kind: "obfs", Name: "http_mix"}, ErrUnsupported, "unsupported obfs type: http_mix"},
+		{&ConfigError{Field: "port", Err: io.EOF}, ErrConfig, "invalid config port"},
+		{&ConfigError{Err: io.EOF}, ErrConfig, "invalid config: EOF"},
+	} {
+		// the kind is found through wrapping
+		err := fmt.Errorf("wrapped: %w", test.err)
+		for _, kind := range kinds {
+			if errors.Is(err, kind) != (kind == test.kind) {
+				t.Errorf("%v: errors.Is(%v) = %v", test.err, kind, !(kind == test.kind))
+			}
+		}
+		if !strings.Contains(test.err.Error(), test.msg) {
+			t.Errorf("%q does not contain %q", test.err.Error(), test.msg)
+		}
+		if _, unsupported := test.err.(*UnsupportedError); !unsupported && !errors.Is(err, io.EOF) {
+			t.Errorf("%v: the cause is not unwrapped", test.err)
+		}
+	}
+
+	var de *DialError
+	if err := fmt.Errorf("wrapped: %w", &DialError{Addr: "1.2.3.4:443", Err: io.EOF}); !errors.As(err, &de) || de.Addr != "1.2.3.4:443" {
+		t.Errorf("errors.As: got %v", de)
+	}
+	var ue *UnsupportedError
+	if err := fmt.Errorf("wrapped: %w", &UnsupportedError{Kind: "method", Name: "rc2"}); !errors.As(err, &ue) || ue.Kind != "method" {
+		t.Errorf("errors.As: got %v", ue)
+	}
+}
+
+func TestNewDecodeError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		kind error
+	}{
+		{ErrAuthAES128IncorrectHMAC, ErrAuth},
+		{ErrAuthChainIncorrectHMAC, ErrAuth},
+		{fmt.Errorf("%w: server hello", ErrTLS12TicketAuthHMACError), ErrAuth},
+		{ErrTLS12TicketAuthTooShortData, ErrHandshake},
+		{fmt.Errorf("%w: 0x160303", ErrTLS12TicketAuthIncorrectMagicNumber), ErrHandshake},
+		{ErrSimpleObfsHTTPIncorrectResponse, ErrHandshake},
+		{ErrSimpleObfsTLSIncorrectRecord, ErrHandshake},
+		{ErrAuthChainDataLengthError, ErrProtocol},
+		{errors.New("anything else"), ErrProtocol},
+	} {
+		err := NewDecodeError("1.2.3.4:443", "tls1.2_ticket_auth", "auth_chain_a", test.err)
+		if !errors.Is(err, test.kind) || !errors.Is(err, test.err) {
+			t.Errorf("%v: got %v", test.err, err)
+		}
+	}
+	var ae *AuthError
+	if err := NewDecodeError("1.2.3.4:443", "plain", "auth_chain_a", ErrAuthChainIncorrectHMAC); !errors.As(err, &ae) || ae.Protocol != "auth_chain_a" || ae.Addr != "1.2.3.4:443" {
+		t.Errorf("errors.As: got %v", ae)
+	}
+}
@@ -17,6 +17,8 @@ var (
 	ErrTLS12TicketAuthTooShortData         = errors.New("tls1.2_ticket_auth too short data")
 	ErrTLS12TicketAuthHMACError            = errors.New("tls1.2_ticket_auth hmac verifying failed")
 	ErrTLS12TicketAuthIncorrectMagicNumber = errors.New("tls1.2_ticket_auth incorrect magic number")
+	ErrSimpleObfsHTTPIncorrectResponse     = errors.New("simple_obfs_http incorrect response")
+	ErrSimpleObfsTLSIncorrectRecord        = errors.New("simple_obfs_tls incorrect record")
 )
 
 type ServerInfo struct {
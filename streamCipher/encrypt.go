@@ -11,6 +11,7 @@ import (
 	"github.com/v2rayA/shadowsocksR/tools"
 	"github.com/v2rayA/shadowsocksR/tools/leakybuf"
 	"math/rand"
+	"sort"
 
 	"github.com/dgryski/go-camellia"
 	"github.com/dgryski/go-idea"
@@ -213,6 +214,16 @@ var streamCipherMethod = map[string]*cipherInfo{
 	"none":             {16, 0, newNoneStream},
 }
 
+// Methods returns the sorted names of the supported encryption methods.
+func Methods() []string {
+	methods := make([]string, 0, len(streamCipherMethod))
+	for method := range streamCipherMethod {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
 func CheckCipherMethod(method string) error {
 	if method == "" {
 		method = "rc4-md5"
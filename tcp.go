@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"github.com/v2rayA/shadowsocksR/obfs"
 	"github.com/v2rayA/shadowsocksR/protocol"
+	"github.com/v2rayA/shadowsocksR/ssr"
 	"github.com/v2rayA/shadowsocksR/streamCipher"
 	"github.com/v2rayA/shadowsocksR/tools/leakybuf"
+	"io"
 	_ "log"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -29,9 +32,54 @@ type SSTCPConn struct {
 	readIndex           uint64
 	decryptedBuf        *bytes.Buffer
 	writeBuf            []byte
-	lastReadError       error
+	writeClosed         bool
+
+	// ObfsName and ProtocolName are only used to describe errors.
+	ObfsName     string
+	ProtocolName string
+
+	// writeMu serializes the writes, including the send-backs requested by
+	// the obfs while reading, as they share the encryptor state. readMu
+	// serializes the reads. They guard writeBuf and readBuf, which go back to
+	// the pool on Close.
+	writeMu sync.Mutex
+	readMu  sync.Mutex
+
+	closeOnce sync.Once
+	closeErr  error
+
+	// readErr and writeErr are sticky: once the cipher or protocol state of
+	// a direction is out of sync, the connection cannot be used anymore
+	readErr  error
+	writeErr error
+	// pendingReadErr is the error returned by the underlying connection along
+	// with data, reported by Read after that data
+	pendingReadErr error
+
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// errWriteClosed is returned by Write after CloseWrite.
+var errWriteClosed = fmt.Errorf("[ssr] write after CloseWrite: %w", net.ErrClosed)
+
+// brokenError is returned once the state of a connection is out of sync.
+type brokenError struct {
+	err error
+}
+
+func (e *brokenError) Error() string { return "[ssr] broken connection: " + e.err.Error() }
+func (e *brokenError) Unwrap() error { return e.err }
+
+// Timeout reports whether the connection broke because of a timeout.
+func (e *brokenError) Timeout() bool {
+	var ne net.Error
+	return errors.As(e.err, &ne) && ne.Timeout()
 }
 
+func (e *brokenError) Temporary() bool { return false }
+
 func NewSSTCPConn(c net.Conn, cipher *streamCipher.StreamCipher) *SSTCPConn {
 	return &SSTCPConn{
 		Conn:                c,
@@ -43,10 +91,53 @@ func NewSSTCPConn(c net.Conn, cipher *streamCipher.StreamCipher) *SSTCPConn {
 	}
 }
 
+// Close closes the underlying connection, which unblocks pending reads and
+// writes, and returns the buffers to the pool. It may be called more than once.
 func (c *SSTCPConn) Close() error {
-	leakybuf.GlobalLeakyBuf.Put(c.readBuf)
-	leakybuf.GlobalLeakyBuf.Put(c.writeBuf)
-	return c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.closeErr = c.Conn.Close()
+		c.readMu.Lock()
+		leakybuf.GlobalLeakyBuf.Put(c.readBuf)
+		c.readBuf = nil
+		c.readMu.Unlock()
+		c.writeMu.Lock()
+		leakybuf.GlobalLeakyBuf.Put(c.writeBuf)
+		c.writeBuf = nil
+		c.writeMu.Unlock()
+	})
+	return c.closeErr
+}
+
+// CloseWrite sends the data held back by the obfs, then shuts down the writing
+// side of the underlying connection. Reading is still possible.
+func (c *SSTCPConn) CloseWrite() error {
+	cw, ok := c.Conn.(interface{ CloseWrite() error })
+	if !ok {
+		return errors.New("[ssr] the underlying connection does not support half-close")
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if f, ok := c.IObfs.(obfs.IFlusher); ok && c.EncryptInited() && c.writeErr == nil {
+		outData, err := f.Flush()
+		if err == nil {
+			err = c.writeFull(outData)
+		}
+		if err != nil {
+			c.writeErr = &brokenError{err: err}
+			return c.writeErr
+		}
+	}
+	c.writeClosed = true
+	return cw.CloseWrite()
+}
+
+// CloseRead shuts down the reading side of the underlying connection.
+func (c *SSTCPConn) CloseRead() error {
+	cr, ok := c.Conn.(interface{ CloseRead() error })
+	if !ok {
+		return errors.New("[ssr] the underlying connection does not support half-close")
+	}
+	return cr.CloseRead()
 }
 
 func (c *SSTCPConn) GetIv() (iv []byte) {
@@ -87,7 +178,88 @@ func (c *SSTCPConn) initEncryptor(b []byte) (iv []byte, err error) {
 	return
 }
 
+// Handshake completes the obfs handshake if it does not depend on the payload,
+// so that the first write is sent without delay. It does nothing for other obfs.
+func (c *SSTCPConn) Handshake() error {
+	h, ok := c.IObfs.(obfs.IHandshaker)
+	if !ok {
+		return nil
+	}
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readBuf == nil {
+		return net.ErrClosed
+	}
+	for {
+		done, err := c.handshakeWrite(h)
+		if err != nil {
+			return err
+		}
+		if done {
+			break
+		}
+		n, err := c.Conn.Read(c.readBuf)
+		if err != nil {
+			return c.handshakeError(err)
+		}
+		if err = c.handshakeDecode(c.readBuf[:n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handshakeWrite writes the next message of the obfs handshake, if it is not
+// done, and reports whether it is done. It holds writeMu, as the obfs state
+// and the stream are shared with Write and the send-backs of Read.
+func (c *SSTCPConn) handshakeWrite(h obfs.IHandshaker) (done bool, err error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeErr != nil {
+		return false, c.writeErr
+	}
+	if c.writeBuf == nil {
+		return false, net.ErrClosed
+	}
+	if h.HandshakeDone() {
+		return true, nil
+	}
+	obfsServerInfo := c.IObfs.GetServerInfo()
+	obfsServerInfo.Key, obfsServerInfo.KeyLen = c.Key(), c.InfoKeyLen()
+	c.IObfs.SetServerInfo(obfsServerInfo)
+	outData, err := c.IObfs.Encode(nil)
+	if err != nil {
+		return false, c.handshakeError(err)
+	}
+	if err = c.writeFull(outData); err != nil {
+		c.writeErr = &brokenError{err: c.handshakeError(err)}
+		return false, c.writeErr
+	}
+	return h.HandshakeDone(), nil
+}
+
+// handshakeDecode decodes the handshake message of the server. It holds
+// writeMu, as the handshake state of the obfs is shared with Write.
+func (c *SSTCPConn) handshakeDecode(b []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, _, err := c.IObfs.Decode(b); err != nil {
+		return c.decodeError(err)
+	}
+	return nil
+}
+
+// Read reads decoded data. Errors of the obfs, the protocol or of a send-back
+// leave the connection unusable and are returned by every later Read.
 func (c *SSTCPConn) Read(b []byte) (n int, err error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.readErr != nil {
+		return 0, c.readErr
+	}
+	if c.readBuf == nil {
+		return 0, net.ErrClosed
+	}
 	for {
 		n, err = c.doRead(b)
 		if b == nil || n != 0 || err != nil {
@@ -101,19 +273,33 @@ func (c *SSTCPConn) doRead(b []byte) (n int, err error) {
 	if c.decryptedBuf.Len() > 0 {
 		return c.decryptedBuf.Read(b)
 	}
+	if err = c.pendingReadErr; err != nil {
+		c.pendingReadErr = nil
+		return 0, err
+	}
 	n, err = c.Conn.Read(c.readBuf)
-	if n == 0 || err != nil {
-		return n, err
+	if n == 0 {
+		return 0, err
 	}
-	decodedData, needSendBack, err := c.IObfs.Decode(c.readBuf[:n])
+	// the data read along with an error is decoded first, and the error is
+	// returned once it has been consumed
+	c.pendingReadErr = err
+	return c.decode(b, c.readBuf[:n])
+}
+
+// decode decodes data read from the underlying connection into b.
+func (c *SSTCPConn) decode(b []byte, data []byte) (n int, err error) {
+	decodedData, needSendBack, err := c.IObfs.Decode(data)
 	if err != nil {
 		//log.Println(c.Conn.LocalAddr().String(), c.IObfs.(*obfs.tls12TicketAuth).handshakeStatus, err)
-		return 0, err
+		return 0, c.breakRead(c.decodeError(err))
 	}
 
 	//do send back
 	if needSendBack {
-		c.Write(nil)
+		if err = c.sendBack(); err != nil {
+			return 0, c.breakRead(err)
+		}
 		//log.Println("sendBack")
 		return 0, nil
 	}
@@ -129,11 +315,11 @@ func (c *SSTCPConn) doRead(b []byte) (n int, err error) {
 	if !c.DecryptInited() {
 
 		if len(decodedData) < c.InfoIVLen() {
-			return 0, errors.New(fmt.Sprintf("invalid ivLen:%v, actual length:%v", c.InfoIVLen(), len(decodedData)))
+			return 0, c.breakRead(c.decodeError(errors.New(fmt.Sprintf("invalid ivLen:%v, actual length:%v", c.InfoIVLen(), len(decodedData)))))
 		}
 		iv := decodedData[0:c.InfoIVLen()]
 		if err = c.InitDecrypt(iv); err != nil {
-			return 0, err
+			return 0, c.breakRead(err)
 		}
 
 		if len(c.IV()) == 0 {
@@ -158,7 +344,7 @@ func (c *SSTCPConn) doRead(b []byte) (n int, err error) {
 		c.underPostdecryptBuf.Reset()
 		//log.Println(string(decodebytes))
 		//log.Println("err", err)
-		return 0, err
+		return 0, c.breakRead(c.decodeError(err))
 	}
 	if length == 0 {
 		// not enough to postDecrypt
@@ -212,14 +398,135 @@ func (c *SSTCPConn) preWrite(b []byte) (outData []byte, err error) {
 	return c.IObfs.Encode(cipherData)
 }
 
+// Write encodes and writes b, returning len(b) once all of its encoded form
+// has been written. A write deadline that has already passed is reported
+// without changing any state, but a failure while writing the encoded data
+// leaves the connection unusable and is returned by every later Write.
 func (c *SSTCPConn) Write(b []byte) (n int, err error) {
-	outData, err := c.preWrite(b)
-	if err != nil {
-		return 0, err
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeErr != nil {
+		return 0, c.writeErr
 	}
-	n, err = c.Conn.Write(outData)
-	if err != nil {
+	if c.writeBuf == nil {
+		return 0, net.ErrClosed
+	}
+	if c.writeClosed {
+		return 0, errWriteClosed
+	}
+	c.deadlineMu.Lock()
+	deadline := c.writeDeadline
+	c.deadlineMu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, &timeoutError{}
+	}
+	if err = c.write(b); err != nil {
 		return 0, err
 	}
 	return len(b), nil
 }
+
+// write encodes and writes b, c.writeMu must be held.
+func (c *SSTCPConn) write(b []byte) error {
+	outData, err := c.preWrite(b)
+	if err != nil {
+		c.writeErr = &brokenError{err: err}
+		return c.writeErr
+	}
+	if err = c.writeFull(outData); err != nil {
+		c.writeErr = &brokenError{err: err}
+		return c.writeErr
+	}
+	return nil
+}
+
+// writeFull writes all of b to the underlying connection, retrying short writes.
+func (c *SSTCPConn) writeFull(b []byte) error {
+	for len(b) > 0 {
+		n, err := c.Conn.Write(b)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// sendBack writes the empty payload requested by the obfs while reading. It
+// is bound by the read deadline as well as the write deadline.
+func (c *SSTCPConn) sendBack() error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.writeClosed {
+		return nil
+	}
+	if c.writeErr != nil {
+		return c.writeErr
+	}
+	c.deadlineMu.Lock()
+	deadline := c.writeDeadline
+	if !c.readDeadline.IsZero() && (deadline.IsZero() || c.readDeadline.Before(deadline)) {
+		deadline = c.readDeadline
+	}
+	c.deadlineMu.Unlock()
+	if err := c.Conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	err := c.write(nil)
+
+	c.deadlineMu.Lock()
+	c.Conn.SetWriteDeadline(c.writeDeadline)
+	c.deadlineMu.Unlock()
+	return err
+}
+
+// breakRead makes err the sticky read error, for errors that leave the
+// decoder state out of sync.
+func (c *SSTCPConn) breakRead(err error) error {
+	c.readErr = &brokenError{err: err}
+	return c.readErr
+}
+
+// decodeError adds the server address, obfs and protocol to err, returned
+// while decoding the data of the server.
+func (c *SSTCPConn) decodeError(err error) error {
+	return ssr.NewDecodeError(c.RemoteAddr().String(), c.ObfsName, c.ProtocolName, err)
+}
+
+func (c *SSTCPConn) handshakeError(err error) error {
+	return &ssr.HandshakeError{Addr: c.RemoteAddr().String(), Obfs: c.ObfsName, Err: err}
+}
+
+// SetDeadline implements net.Conn.
+func (c *SSTCPConn) SetDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.Conn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn. The deadline also applies to the
+// send-backs requested by the obfs while reading.
+func (c *SSTCPConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *SSTCPConn) SetWriteDeadline(t time.Time) error {
+	c.deadlineMu.Lock()
+	defer c.deadlineMu.Unlock()
+	c.writeDeadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "[ssr] i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
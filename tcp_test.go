@@ -0,0 +1,472 @@
+package shadowsocksr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"github.com/v2rayA/shadowsocksR/ssr"
+	"github.com/v2rayA/shadowsocksR/streamCipher"
+	"github.com/v2rayA/shadowsocksR/tools"
+	"github.com/v2rayA/shadowsocksR/tools/leakybuf"
+)
+
+// newTestConn wraps c with the cipher, obfs and protocol of cfg, with the
+// shared data of its own, as set up by client.SSR.
+func newTestConn(t *testing.T, c net.Conn, cfg *config.Config) *SSTCPConn {
+	ssconn, err := NewSSTCPConnFromConfig(c, cfg, "127.0.0.1", 8388)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssconn.IObfs.SetData(ssconn.IObfs.GetData())
+	ssconn.IProtocol.SetData(ssconn.IProtocol.GetData())
+	return ssconn
+}
+
+func TestClose(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret"}
+	ssconn := newTestConn(t, c, cfg)
+	for i := 0; i < 3; i++ {
+		if err := ssconn.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ssconn.Read(make([]byte, 16)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("read after close: %v", err)
+	}
+	if _, err := ssconn.Write([]byte("payload")); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close: %v", err)
+	}
+
+	// the buffers were returned to the pool once
+	seen := make(map[*byte]bool)
+	var bufs [][]byte
+	for i := 0; i < 4; i++ {
+		b := leakybuf.GlobalLeakyBuf.Get()
+		if seen[&b[0]] {
+			t.Fatal("a buffer was returned to the pool twice")
+		}
+		seen[&b[0]] = true
+		bufs = append(bufs, b)
+	}
+	for _, b := range bufs {
+		leakybuf.GlobalLeakyBuf.Put(b)
+	}
+}
+
+// tcpPair returns both ends of a TCP connection, which supports half-close
+// unlike net.Pipe.
+func tcpPair(t *testing.T) (client, server *net.TCPConn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-accepted
+	if s == nil {
+		t.Fatal("accept failed")
+	}
+	return c.(*net.TCPConn), s.(*net.TCPConn)
+}
+
+// readRecord reads a TLS record.
+func readRecord(r io.Reader) (typ byte, payload []byte, err error) {
+	var h [5]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, binary.BigEndian.Uint16(h[3:]))
+	_, err = io.ReadFull(r, payload)
+	return h[0], payload, err
+}
+
+// serverHello returns the records of the server hello of tls1.2_ticket_auth
+// to the client ID, with the key of the cipher: ServerHello,
+// ChangeCipherSpec and Finished.
+func serverHello(key, clientID []byte) []byte {
+	hello := make([]byte, 4+2+32+1+32)
+	hello[0] = 0x02
+	binary.BigEndian.PutUint16(hello[2:], uint16(len(hello)-4))
+	copy(hello[4:], "\x03\x03")
+	auth := hello[6 : 6+32]
+	copy(auth[22:], tools.HmacSHA1(append(append([]byte(nil), key...), clientID...), auth[:22])[:ssr.ObfsHMACSHA1Len])
+	hello[6+32] = 0x20
+	copy(hello[6+32+1:], clientID)
+
+	b := append([]byte("\x16\x03\x03\x00\x00"), hello...)
+	binary.BigEndian.PutUint16(b[3:], uint16(len(hello)))
+	b = append(b, "\x14\x03\x03\x00\x01\x01"...)
+	b = append(b, "\x16\x03\x03\x00\x20"...)
+	return append(b, make([]byte, 32)...)
+}
+
+// TestCloseWriteTLS12TicketAuth half-closes a connection before the server
+// hello of tls1.2_ticket_auth was received, which makes the obfs send its
+// handshake finish and the data held back with it.
+func TestCloseWriteTLS12TicketAuth(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret", Obfs: "tls1.2_ticket_auth"}
+	c, s := tcpPair(t)
+	defer s.Close()
+	client := newTestConn(t, c, cfg)
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("late")); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("write after CloseWrite: %v", err)
+	}
+
+	// the server gets the ClientHello, the handshake finish, the data, then EOF
+	typ, hello, err := readRecord(s)
+	if err != nil || typ != 0x16 {
+		t.Fatalf("ClientHello: %#x, %v", typ, err)
+	}
+	clientID := hello[4+2+32+1 : 4+2+32+1+32]
+	if typ, _, err = readRecord(s); err != nil || typ != 0x14 {
+		t.Fatalf("change cipher spec: %#x, %v", typ, err)
+	}
+	if typ, _, err = readRecord(s); err != nil || typ != 0x16 {
+		t.Fatalf("finished: %#x, %v", typ, err)
+	}
+	var payload []byte
+	for {
+		typ, b, err := readRecord(s)
+		if err == io.EOF {
+			break
+		}
+		if err != nil || typ != 0x17 {
+			t.Fatalf("application data: %#x, %v", typ, err)
+		}
+		payload = append(payload, b...)
+	}
+	cipher, err := streamCipher.NewStreamCipher(cfg.Method, cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivLen := cipher.InfoIVLen()
+	if err = cipher.InitDecrypt(payload[:ivLen]); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len(payload)-ivLen)
+	cipher.Decrypt(data, payload[ivLen:])
+	if string(data) != "ping" {
+		t.Fatalf("the server got %q", data)
+	}
+
+	type result struct {
+		b   []byte
+		err error
+	}
+	read := make(chan result, 1)
+	go func() {
+		b, err := ioutil.ReadAll(client)
+		read <- result{b, err}
+	}()
+
+	// the server hello is checked, and not answered as the handshake is over,
+	// and the data that comes with it is decoded
+	iv, err := cipher.InitEncrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := make([]byte, 5+len(iv)+4)
+	copy(reply, "\x17\x03\x03")
+	binary.BigEndian.PutUint16(reply[3:], uint16(len(iv)+4))
+	copy(reply[5:], iv)
+	cipher.Encrypt(reply[5+len(iv):], []byte("pong"))
+	if _, err = s.Write(append(serverHello(cipher.Key(), clientID), reply...)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if r := <-read; r.err != nil || !bytes.Equal(r.b, []byte("pong")) {
+		t.Fatalf("got %q, %v", r.b, r.err)
+	}
+}
+
+// TestHandshakeConcurrentWrite writes while Handshake answers the server
+// hello of tls1.2_ticket_auth: whichever encodes first, the data is sent
+// after the handshake finish.
+func TestHandshakeConcurrentWrite(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret", Obfs: "tls1.2_ticket_auth"}
+	c, s := tcpPair(t)
+	defer s.Close()
+	client := newTestConn(t, c, cfg)
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	s.SetDeadline(time.Now().Add(5 * time.Second))
+
+	handshake := make(chan error, 1)
+	go func() {
+		handshake <- client.Handshake()
+	}()
+	typ, hello, err := readRecord(s)
+	if err != nil || typ != 0x16 {
+		t.Fatalf("ClientHello: %#x, %v", typ, err)
+	}
+	clientID := hello[4+2+32+1 : 4+2+32+1+32]
+
+	cipher, err := streamCipher.NewStreamCipher(cfg.Method, cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.Write(serverHello(cipher.Key(), clientID)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-handshake; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []byte{0x14, 0x16, 0x17} {
+		typ, payload, err := readRecord(s)
+		if err != nil || typ != want {
+			t.Fatalf("record %#x, %v, want %#x", typ, err, want)
+		}
+		if typ != 0x17 {
+			continue
+		}
+		ivLen := cipher.InfoIVLen()
+		if err = cipher.InitDecrypt(payload[:ivLen]); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, len(payload)-ivLen)
+		cipher.Decrypt(data, payload[ivLen:])
+		if string(data) != "ping" {
+			t.Fatalf("the server got %q", data)
+		}
+	}
+}
+
+func TestCloseRead(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret"}
+	c, s := tcpPair(t)
+	defer s.Close()
+	client := newTestConn(t, c, cfg)
+	defer client.Close()
+	if err := client.CloseRead(); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if n, err := client.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+		t.Fatalf("read after CloseRead: %v, %v", n, err)
+	}
+	// writing is still possible
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	// net.Pipe cannot be half-closed
+	p, q := net.Pipe()
+	defer q.Close()
+	pipe := newTestConn(t, p, cfg)
+	defer pipe.Close()
+	if pipe.CloseRead() == nil || pipe.CloseWrite() == nil {
+		t.Fatal("expected half-close errors over net.Pipe")
+	}
+}
+
+// encryptTestData returns data as sent by an SSR server with the method of
+// cfg, the plain obfs and the origin protocol.
+func encryptTestData(t *testing.T, cfg *config.Config, data []byte) []byte {
+	cipher, err := streamCipher.NewStreamCipher(cfg.Method, cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := cipher.InitEncrypt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(iv)+len(data))
+	copy(b, iv)
+	cipher.Encrypt(b[len(iv):], data)
+	return b
+}
+
+// decryptTestData decrypts data sent with the method of cfg, the plain obfs
+// and the origin protocol.
+func decryptTestData(t *testing.T, cfg *config.Config, b []byte) []byte {
+	cipher, err := streamCipher.NewStreamCipher(cfg.Method, cfg.Password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ivLen := cipher.InfoIVLen()
+	if len(b) < ivLen {
+		t.Fatalf("%d bytes without IV", len(b))
+	}
+	if err = cipher.InitDecrypt(b[:ivLen]); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, len(b)-ivLen)
+	cipher.Decrypt(data, b[ivLen:])
+	return data
+}
+
+// dataErrConn returns data along with err from its first Read, like some
+// TLS and WebSocket connections.
+type dataErrConn struct {
+	net.Conn
+	data []byte
+	err  error
+}
+
+func (c *dataErrConn) Read(b []byte) (int, error) {
+	n := copy(b, c.data)
+	c.data = c.data[n:]
+	return n, c.err
+}
+
+func TestReadDataWithError(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret"}
+	for _, readErr := range []error{io.EOF, errors.New("connection lost")} {
+		p, q := net.Pipe()
+		msg := []byte("Don't tell me the moon is shining")
+		c := newTestConn(t, &dataErrConn{Conn: p, data: encryptTestData(t, cfg, msg), err: readErr}, cfg)
+		// a small buffer keeps part of the data in the decrypted buffer
+		buf := make([]byte, 8)
+		var got []byte
+		var err error
+		for err == nil {
+			var n int
+			n, err = c.Read(buf)
+			got = append(got, buf[:n]...)
+		}
+		if !bytes.Equal(got, msg) || err != readErr {
+			t.Errorf("got %q, %v", got, err)
+		}
+		c.Close()
+		q.Close()
+	}
+}
+
+func TestShortWrite(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret"}
+	p, q := net.Pipe()
+	defer q.Close()
+	c := newTestConn(t, &testutil.ShortWriteConn{Conn: p, Max: 3}, cfg)
+	received := make(chan []byte, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(q)
+		received <- b
+	}()
+	msg := bytes.Repeat([]byte("Don't tell me the moon is shining"), 100)
+	if n, err := c.Write(msg); n != len(msg) || err != nil {
+		t.Fatalf("wrote %d bytes, %v", n, err)
+	}
+	c.Close()
+	if b := decryptTestData(t, cfg, <-received); !bytes.Equal(b, msg) {
+		t.Fatalf("the server got %d bytes, want %d", len(b), len(msg))
+	}
+
+	// a write that makes no progress breaks the connection
+	p, q = net.Pipe()
+	defer q.Close()
+	c = newTestConn(t, &testutil.ShortWriteConn{Conn: p, Max: 0}, cfg)
+	defer c.Close()
+	if _, err := c.Write(msg); !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("got %v, want io.ErrShortWrite", err)
+	}
+}
+
+func TestStickyErrors(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret"}
+	p, q := net.Pipe()
+	defer q.Close()
+	go io.Copy(ioutil.Discard, q)
+	failure := errors.New("connection lost")
+	c := newTestConn(t, &testutil.ShortWriteConn{Conn: p, Max: 1 << 16, Failures: 1, Err: failure}, cfg)
+	defer c.Close()
+	_, err := c.Write([]byte("ping"))
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v", err)
+	}
+	// the encryptor state is lost, even if the connection works again
+	if _, err2 := c.Write([]byte("ping")); err2 != err {
+		t.Fatalf("second write: %v", err2)
+	}
+
+	// an invalid server hello breaks the reading side
+	cfg.Obfs = "tls1.2_ticket_auth"
+	p, q = net.Pipe()
+	defer q.Close()
+	c = newTestConn(t, p, cfg)
+	defer c.Close()
+	go func() {
+		ioutil.ReadAll(q)
+	}()
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	go q.Write(make([]byte, 11+32+1+32))
+	_, err = c.Read(make([]byte, 16))
+	if !errors.Is(err, ssr.ErrAuth) {
+		t.Fatalf("got %v, want an authentication error", err)
+	}
+	if _, err2 := c.Read(make([]byte, 16)); err2 != err {
+		t.Fatalf("second read: %v", err2)
+	}
+}
+
+// TestSendBackDeadline checks that a send-back requested while reading is
+// bound by the read deadline, even without write deadline.
+func TestSendBackDeadline(t *testing.T) {
+	cfg := &config.Config{Method: "chacha20-ietf", Password: "Alice's secret", Obfs: "random_head"}
+	p, q := net.Pipe()
+	defer q.Close()
+	c := newTestConn(t, p, cfg)
+	defer c.Close()
+	// the server answers, but does not read the send-back
+	go q.Write([]byte("random reply"))
+	start := time.Now()
+	c.SetReadDeadline(start.Add(100 * time.Millisecond))
+	_, err := c.Read(make([]byte, 16))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("the send-back took %v", elapsed)
+	}
+
+	// a write deadline that has passed is reported without breaking the connection
+	p, q = net.Pipe()
+	defer q.Close()
+	cfg.Obfs = ""
+	c = newTestConn(t, p, cfg)
+	defer c.Close()
+	c.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err = c.Write([]byte("ping")); !errors.As(err, &ne) || !ne.Timeout() {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	c.SetWriteDeadline(time.Time{})
+	go io.Copy(ioutil.Discard, q)
+	if _, err = c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+}
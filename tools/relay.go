@@ -0,0 +1,37 @@
+package tools
+
+import (
+	"io"
+	"net"
+	"sync"
+)
+
+// Relay copies data between left and right in both directions until both
+// directions are finished, then closes the two connections.
+func Relay(left, right net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyHalf(right, left)
+	}()
+	go func() {
+		defer wg.Done()
+		copyHalf(left, right)
+	}()
+	wg.Wait()
+	left.Close()
+	right.Close()
+}
+
+// copyHalf copies src to dst and signals EOF to dst when src is drained.
+// If dst cannot be half-closed both connections are closed, which unblocks
+// the copy running in the opposite direction.
+func copyHalf(dst, src net.Conn) {
+	_, _ = io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		return
+	}
+	dst.Close()
+	src.Close()
+}
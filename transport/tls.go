@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// TLS is a dialer that wraps connections in TLS. Unlike the tls1.2_ticket_auth
+// obfs, it makes a genuine TLS handshake, and the obfs and protocol of the SSR
+// connection run inside it.
+type TLS struct {
+	// Dialer connects the server, proxy.Direct if nil.
+	Dialer proxy.Dialer
+	// ServerName is the SNI and the name the certificate is verified for,
+	// the host of the dialed address if empty.
+	ServerName string
+	// ALPN is the list of application protocols offered to the server.
+	ALPN []string
+	// RootCAs verifies the certificate of the server, the system pool if nil.
+	RootCAs *x509.CertPool
+	// Pins are SHA-256 hashes of certificates, as returned by ParsePin. If
+	// any, the leaf certificate of the server must be one of them, and its
+	// chain is not verified against RootCAs, which allows self-signed
+	// certificates.
+	Pins [][]byte
+}
+
+// Dial connects to addr and makes the TLS handshake.
+func (t *TLS) Dial(network, addr string) (net.Conn, error) {
+	d := t.Dialer
+	if d == nil {
+		d = proxy.Direct
+	}
+	config, err := t.config(addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(c, config)
+	tc.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err = tc.Handshake(); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("[tls] handshake with %v: %w", addr, err)
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// config returns the TLS config of a connection to addr.
+func (t *TLS) config(addr string) (*tls.Config, error) {
+	name := t.ServerName
+	if name == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("[tls] %w", err)
+		}
+		name = host
+	}
+	config := &tls.Config{
+		ServerName: name,
+		NextProtos: t.ALPN,
+		RootCAs:    t.RootCAs,
+	}
+	if len(t.Pins) > 0 {
+		// the chain is replaced by the pins
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = t.verifyPins
+	}
+	return config, nil
+}
+
+// verifyPins checks that the certificate of the server is pinned. Only the
+// leaf is compared, as the rest of the chain is not verified and anyone may
+// append a pinned certificate to it.
+func (t *TLS) verifyPins(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("[tls] no certificate")
+	}
+	sum := sha256.Sum256(rawCerts[0])
+	for _, pin := range t.Pins {
+		if bytes.Equal(sum[:], pin) {
+			return nil
+		}
+	}
+	return errors.New("[tls] certificate not pinned")
+}
+
+// ParsePin parses the SHA-256 hash of a certificate in hex, its bytes
+// optionally separated by colons as printed by openssl.
+func ParsePin(s string) ([]byte, error) {
+	pin, err := hex.DecodeString(strings.Replace(strings.TrimSpace(s), ":", "", -1))
+	if err != nil {
+		return nil, fmt.Errorf("[tls] invalid pin %q: %w", s, err)
+	}
+	if len(pin) != sha256.Size {
+		return nil, fmt.Errorf("[tls] invalid pin %q: not a SHA-256 hash", s)
+	}
+	return pin, nil
+}
+
+// LoadCertPool returns a pool of the PEM certificates of file.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("[tls] %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("[tls] no certificate in %v", file)
+	}
+	return pool, nil
+}
+
+// ListenTLS listens on the TCP address addr and serves TLS with the
+// certificate and key of PEM files, negotiating one of the alpn protocols
+// if the client offers any.
+func ListenTLS(addr, certFile, keyFile string, alpn []string) (net.Listener, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("[tls] %w", err)
+	}
+	return tls.Listen("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   alpn,
+	})
+}
@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/v2rayA/shadowsocksR/client"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"github.com/v2rayA/shadowsocksR/server"
+	"golang.org/x/net/proxy"
+)
+
+// newCert returns a self-signed DER certificate of example.com and its key.
+func newCert(t *testing.T) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.com"},
+		DNSNames:              []string{"example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeCert writes a self-signed certificate of example.com and its key to
+// cert.pem and key.pem of dir, and returns the DER certificate.
+func writeCert(t *testing.T, dir string) []byte {
+	cert, key := newCert(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err = ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// listenTLSEcho starts a TLS server that echoes what it receives.
+func listenTLSEcho(t *testing.T, dir string, alpn []string) net.Listener {
+	l, err := ListenTLS("127.0.0.1:0", filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), alpn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cert := writeCert(t, dir)
+	pool, err := LoadCertPool(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := listenTLSEcho(t, dir, []string{"h2", "http/1.1"})
+	defer l.Close()
+	addr := l.Addr().String()
+	sum := sha256.Sum256(cert)
+	pin, err := ParsePin(hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// verified with the CA pool for the SNI
+	d := &TLS{ServerName: "example.com", ALPN: []string{"http/1.1"}, RootCAs: pool}
+	c, err := d.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, c)
+	if p := c.(*tls.Conn).ConnectionState().NegotiatedProtocol; p != "http/1.1" {
+		t.Errorf("negotiated protocol %q, want http/1.1", p)
+	}
+	c.Close()
+
+	// pinned, whatever the server name
+	d = &TLS{Pins: [][]byte{pin}}
+	if c, err = d.Dial("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, c)
+	c.Close()
+
+	for _, d := range []*TLS{
+		{ServerName: "example.com"},
+		{ServerName: "example.org", RootCAs: pool},
+		{Pins: [][]byte{make([]byte, sha256.Size)}},
+	} {
+		if c, err := d.Dial("tcp", addr); err == nil {
+			c.Close()
+			t.Errorf("server name %q: expected a verification error", d.ServerName)
+		}
+	}
+}
+
+// TestTLSPinLeaf checks that a pinned certificate appended to the chain of
+// another one is not accepted.
+func TestTLSPinLeaf(t *testing.T) {
+	leaf, key := newCert(t)
+	pinned, _ := newCert(t)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf, pinned}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	sum := sha256.Sum256(pinned)
+	d := &TLS{Pins: [][]byte{sum[:]}}
+	if c, err := d.Dial("tcp", l.Addr().String()); err == nil {
+		c.Close()
+		t.Fatal("accepted a chain with the pinned certificate after the leaf")
+	}
+	sum = sha256.Sum256(leaf)
+	d = &TLS{Pins: [][]byte{sum[:]}}
+	c, err := d.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.EchoThrough(t, c)
+	c.Close()
+}
+
+func TestParsePin(t *testing.T) {
+	sum := sha256.Sum256([]byte("certificate"))
+	s := hex.EncodeToString(sum[:])
+	var colons string
+	for i := 0; i < len(s); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += s[i : i+2]
+	}
+	for _, s := range []string{s, colons} {
+		if pin, err := ParsePin(s); err != nil || string(pin) != string(sum[:]) {
+			t.Errorf("%s: got %x, %v", s, pin, err)
+		}
+	}
+	for _, s := range []string{"", "zz", s[:10]} {
+		if _, err := ParsePin(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestTLSWebSocketSSR(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeCert(t, dir)
+	pool, err := LoadCertPool(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+
+	ln, err := ListenTLS("127.0.0.1:0", filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := ServeWebSocket(ln, "/ssr")
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	cfg := &config.Config{Server: "127.0.0.1", Port: port, Method: "chacha20-ietf", Password: "Alice's secret"}
+	s, err := server.New(cfg, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+
+	d := &WebSocket{Dialer: &TLS{ServerName: "example.com", RootCAs: pool}, Path: "/ssr"}
+	ssr, err := client.NewSSRFromConfig(cfg, d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ssr.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testutil.EchoThrough(t, c)
+}
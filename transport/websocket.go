@@ -0,0 +1,232 @@
+// Package transport carries SSR streams over other protocols. Its dialers
+// wrap the dialer of the server connection, under SSTCPConn, and its
+// listeners yield the connections of the server side.
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/net/websocket"
+)
+
+// handshakeTimeout bounds the time of the WebSocket handshake.
+const handshakeTimeout = 30 * time.Second
+
+// ErrListenerClosed is returned by Accept after Close.
+var ErrListenerClosed = errors.New("[websocket] listener closed")
+
+// WebSocket is a dialer that carries connections in the binary frames of a
+// WebSocket. It connects the server with Dialer, and requests Path with the
+// Host header, so that it can go through HTTP reverse proxies and CDNs.
+type WebSocket struct {
+	// Dialer connects the server, proxy.Direct if nil.
+	Dialer proxy.Dialer
+	// Path is the request path, "/" if empty. It may have a query.
+	Path string
+	// Host is the Host header, the dialed address if empty.
+	Host string
+	// Header holds extra request headers. An Origin header replaces the
+	// default origin, http:// followed by the host.
+	Header http.Header
+}
+
+// Dial connects to addr and makes the WebSocket handshake.
+func (w *WebSocket) Dial(network, addr string) (net.Conn, error) {
+	d := w.Dialer
+	if d == nil {
+		d = proxy.Direct
+	}
+	config, err := w.config(addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := d.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(handshakeTimeout))
+	ws, err := websocket.NewClient(config, c)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("[websocket] handshake with %v: %w", addr, err)
+	}
+	c.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	return &wsConn{Conn: ws, local: c.LocalAddr(), remote: c.RemoteAddr()}, nil
+}
+
+// config returns the handshake config of a connection to addr.
+func (w *WebSocket) config(addr string) (*websocket.Config, error) {
+	host := w.Host
+	if host == "" {
+		host = addr
+	}
+	path := w.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	header := make(http.Header, len(w.Header))
+	for k, v := range w.Header {
+		header[k] = v
+	}
+	origin := header.Get("Origin")
+	if origin == "" {
+		origin = "http://" + host
+	}
+	header.Del("Origin")
+	config, err := websocket.NewConfig("ws://"+host+path, origin)
+	if err != nil {
+		return nil, fmt.Errorf("[websocket] %w", err)
+	}
+	config.Header = header
+	return config, nil
+}
+
+// ParseHeader parses a "Key: Value" header line into h.
+func ParseHeader(h http.Header, s string) error {
+	i := strings.IndexByte(s, ':')
+	if i <= 0 {
+		return fmt.Errorf("[websocket] invalid header: %q", s)
+	}
+	h.Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
+// WebSocketListener is a net.Listener of the WebSocket connections it serves
+// as an http.Handler, either on its own port with ListenWebSocket or behind
+// an HTTP server or reverse proxy.
+type WebSocketListener struct {
+	path   string
+	addr   net.Addr
+	server *http.Server
+
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebSocketListener returns a listener of the WebSocket requests of path,
+// any path if empty, served by its ServeHTTP. addr is its Addr.
+func NewWebSocketListener(path string, addr net.Addr) *WebSocketListener {
+	return &WebSocketListener{
+		path:  path,
+		addr:  addr,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// ListenWebSocket listens on the TCP address addr and serves the WebSocket
+// requests of path.
+func ListenWebSocket(addr, path string) (*WebSocketListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return ServeWebSocket(ln, path), nil
+}
+
+// ServeWebSocket serves the WebSocket requests of path on ln, which may be a
+// TLS listener. Closing the returned listener closes ln.
+func ServeWebSocket(ln net.Listener, path string) *WebSocketListener {
+	l := NewWebSocketListener(path, ln.Addr())
+	l.server = &http.Server{Handler: l, ReadHeaderTimeout: handshakeTimeout}
+	go l.server.Serve(ln)
+	return l
+}
+
+// ServeHTTP makes the WebSocket handshake and hands the connection to Accept.
+// It returns once the connection is closed.
+func (l *WebSocketListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if l.path != "" && r.URL.Path != l.path {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case <-l.done:
+		http.Error(w, "closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	websocket.Server{Handler: l.handle}.ServeHTTP(w, r)
+}
+
+func (l *WebSocketListener) handle(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+	remote, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr)
+	if err != nil {
+		return
+	}
+	c := &wsConn{Conn: ws, local: l.addr, remote: remote, closed: make(chan struct{})}
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		return
+	}
+	// the connection is closed when the handler returns
+	<-c.closed
+}
+
+// Accept waits for the next WebSocket connection.
+func (l *WebSocketListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, ErrListenerClosed
+	}
+}
+
+// Close stops accepting connections, and stops listening if the listener was
+// returned by ListenWebSocket or ServeWebSocket. Accepted connections are not
+// closed.
+func (l *WebSocketListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		if l.server != nil {
+			l.server.Close()
+		}
+	})
+	return nil
+}
+
+// Addr returns the address given to NewWebSocketListener, or the listening
+// address.
+func (l *WebSocketListener) Addr() net.Addr {
+	return l.addr
+}
+
+// wsConn is a WebSocket connection with the addresses of the underlying
+// connection, instead of the WebSocket location and origin.
+type wsConn struct {
+	*websocket.Conn
+	local, remote net.Addr
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *wsConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *wsConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		if c.closed != nil {
+			close(c.closed)
+		}
+	})
+	return err
+}
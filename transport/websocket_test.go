@@ -0,0 +1,93 @@
+package transport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/v2rayA/shadowsocksR/client"
+	"github.com/v2rayA/shadowsocksR/config"
+	"github.com/v2rayA/shadowsocksR/internal/testutil"
+	"github.com/v2rayA/shadowsocksR/server"
+	"golang.org/x/net/proxy"
+)
+
+func TestWebSocket(t *testing.T) {
+	l := NewWebSocketListener("/ws", nil)
+	defer l.Close()
+	var req *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		l.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	addr := strings.TrimPrefix(ts.URL, "http://")
+	header := http.Header{}
+	if err := ParseHeader(header, "X-Token: secret"); err != nil {
+		t.Fatal(err)
+	}
+	w := &WebSocket{Path: "/ws", Host: "cdn.example.com", Header: header}
+	c, err := w.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testutil.EchoThrough(t, c)
+	if req.Host != "cdn.example.com" || req.Header.Get("X-Token") != "secret" {
+		t.Fatalf("unexpected request: host %q, header %v", req.Host, req.Header)
+	}
+	if c.RemoteAddr().String() != addr {
+		t.Fatalf("remote address %v, want %v", c.RemoteAddr(), addr)
+	}
+
+	w.Path = "/other"
+	if c, err := w.Dial("tcp", addr); err == nil {
+		c.Close()
+		t.Fatal("expected an error for another path")
+	}
+}
+
+func TestWebSocketSSR(t *testing.T) {
+	echo := testutil.ListenEcho(t)
+	defer echo.Close()
+
+	l, err := ListenWebSocket("127.0.0.1:0", "/ssr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	cfg := &config.Config{Server: "127.0.0.1", Port: port, Method: "aes-256-cfb", Password: "Alice's secret"}
+	s, err := server.New(cfg, proxy.Direct, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	defer l.Close()
+
+	d, err := client.NewSSRFromConfig(cfg, &WebSocket{Path: "/ssr"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := d.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	testutil.EchoThrough(t, c)
+}